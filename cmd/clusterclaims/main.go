@@ -14,10 +14,14 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
+	addonv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
 	mcv1 "open-cluster-management.io/api/cluster/v1"
+	mcv1beta2 "open-cluster-management.io/api/cluster/v1beta2"
 	ctrl "sigs.k8s.io/controller-runtime"
-	"sigs.k8s.io/controller-runtime/pkg/metrics/server"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/metrics/server"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 	// +kubebuilder:scaffold:imports
 )
 
@@ -31,6 +35,8 @@ func init() {
 
 	_ = hivev1.AddToScheme(scheme)
 	_ = mcv1.AddToScheme(scheme)
+	_ = addonv1alpha1.AddToScheme(scheme)
+	_ = mcv1beta2.AddToScheme(scheme)
 	// +kubebuilder:scaffold:scheme
 }
 
@@ -40,6 +46,21 @@ func main() {
 	var leaderElectionLeaseDuration time.Duration
 	var leaderElectionRenewDeadline time.Duration
 	var leaderElectionRetryPeriod time.Duration
+	var syncLabels bool
+	var deleteTimeout time.Duration
+	var deletionPolicy string
+	var createKlusterletAddonConfig bool
+	var klusterletAddons string
+	var derivedLabels string
+	var labelAllow string
+	var labelDeny string
+	var labelRewrite string
+	var propagateClusterSet bool
+	var authorizeClusterSet bool
+	var repairManagedCluster bool
+	var enableWebhook bool
+	var webhookPort int
+	var webhookCertDir string
 	flag.StringVar(&metricsAddr, "metrics-addr", ":9443", "The address the metric endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
 		"Enable leader election for controller manager. "+
@@ -69,8 +90,65 @@ func main() {
 		"The duration the clients should wait between attempting acquisition and renewal "+
 			"of a leadership. This is only applicable if leader election is enabled.",
 	)
+	flag.BoolVar(&syncLabels, "sync-labels", false,
+		"Keep the ClusterClaim labels on the ManagedCluster in sync after import. "+
+			"Only labels that were copied from the claim are updated or removed.")
+	flag.DurationVar(&deleteTimeout, "managedcluster-delete-timeout", 10*time.Minute,
+		"How long a deleting ClusterClaim waits for its ManagedCluster to be removed before releasing "+
+			"the claim finalizer. Use 0 to wait until the ManagedCluster is gone.")
+	flag.StringVar(&deletionPolicy, "deletion-policy", controller.DeletionPolicyDelete,
+		"What happens to the ManagedCluster when its ClusterClaim is deleted: Delete, Orphan or Detach. "+
+			"A claim can override it with the "+controller.DELETION_POLICY+" annotation.")
+	flag.BoolVar(&createKlusterletAddonConfig, "create-klusterlet-addon-config", false,
+		"Create a KlusterletAddonConfig in the cluster namespace when the ManagedCluster is created.")
+	flag.StringVar(&klusterletAddons, "klusterlet-addons", controller.DefaultKlusterletAddons,
+		"Comma separated add-ons enabled in the KlusterletAddonConfig. A ClusterPool or ClusterClaim "+
+			"can override it with the "+controller.KLUSTERLET_ADDONS+" annotation.")
+	flag.StringVar(&derivedLabels, "derived-labels", "",
+		"Comma separated cluster metadata to add as labels to the claim and ManagedCluster, as field or field=label-key. "+
+			"Fields: openshiftVersion, infraID, clusterID, baseDomain and platform.")
+	flag.StringVar(&labelAllow, "label-allow", "",
+		"Comma separated label key patterns copied from the claim to the ManagedCluster, * matches any characters. All labels when empty.")
+	flag.StringVar(&labelDeny, "label-deny", "",
+		"Comma separated label key patterns never copied from the claim to the ManagedCluster, for example app.kubernetes.io/instance.")
+	flag.StringVar(&labelRewrite, "label-rewrite", "",
+		"Comma separated from-key=to-key renames applied to the labels copied from the claim to the ManagedCluster.")
+	flag.BoolVar(&propagateClusterSet, "propagate-clusterset", false,
+		"Move the ManagedClusters of a pool's claims when the pool's clusterset label changes, unless the claim sets its own.")
+	flag.BoolVar(&authorizeClusterSet, "authorize-clusterset", false,
+		"Only join the ManagedClusterSet labeled on a claim when the claim requester may join it, requires --enable-webhook.")
+	flag.BoolVar(&repairManagedCluster, "repair-managedclusters", false,
+		"Re-create the ManagedCluster of an imported claim when it is removed while the cluster is still provisioned.")
+	flag.BoolVar(&enableWebhook, "enable-webhook", false,
+		"Serve the ClusterClaim validating webhook, and the mutating webhook that records the claim requester.")
+	flag.IntVar(&webhookPort, "webhook-port", 9445, "The port the webhook server listens on.")
+	flag.StringVar(&webhookCertDir, "webhook-cert-dir", "",
+		"The directory with the webhook server tls.crt and tls.key, the controller-runtime default when empty.")
 	flag.Parse()
 
+	if !controller.IsValidDeletionPolicy(deletionPolicy) {
+		setupLog.Error(nil, "invalid deletion policy", "deletion-policy", deletionPolicy)
+		os.Exit(1)
+	}
+
+	labelRules, err := controller.ParseLabelRules(labelAllow, labelDeny, labelRewrite)
+	if err != nil {
+		setupLog.Error(err, "invalid label rules")
+		os.Exit(1)
+	}
+
+	// The requester is only recorded, and trusted, with the mutating webhook
+	if authorizeClusterSet && !enableWebhook {
+		setupLog.Error(nil, "--authorize-clusterset requires --enable-webhook to record the claim requester")
+		os.Exit(1)
+	}
+
+	derivedLabelKeys, err := controller.ParseDerivedLabels(derivedLabels)
+	if err != nil {
+		setupLog.Error(err, "invalid derived labels", "derived-labels", derivedLabels)
+		os.Exit(1)
+	}
+
 	// To run in debug change zapcore.InfoLevel to zapcore.DebugLevel
 	ctrl.SetLogger(zap.New(zap.Level(zapcore.InfoLevel)))
 
@@ -89,6 +167,10 @@ func main() {
 		LeaseDuration:    &leaderElectionLeaseDuration,
 		RenewDeadline:    &leaderElectionRenewDeadline,
 		RetryPeriod:      &leaderElectionRetryPeriod,
+		WebhookServer: webhook.NewServer(webhook.Options{
+			Port:    webhookPort,
+			CertDir: webhookCertDir,
+		}),
 	})
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
@@ -96,9 +178,21 @@ func main() {
 	}
 
 	if err = (&controller.ClusterClaimsReconciler{
-		Client: mgr.GetClient(),
-		Log:    ctrl.Log.WithName("controller").WithName("ClusterClaimsReconciler"),
-		Scheme: mgr.GetScheme(),
+		Client:                      mgr.GetClient(),
+		Log:                         ctrl.Log.WithName("controller").WithName("ClusterClaimsReconciler"),
+		Scheme:                      mgr.GetScheme(),
+		Recorder:                    mgr.GetEventRecorderFor("clusterclaims-controller"),
+		DeleteTimeout:               deleteTimeout,
+		DeletionPolicy:              deletionPolicy,
+		CreateKlusterletAddonConfig: createKlusterletAddonConfig,
+		KlusterletAddons:            klusterletAddons,
+		DerivedLabels:               derivedLabelKeys,
+		LabelRules:                  labelRules,
+		PropagateClusterSet:         propagateClusterSet,
+		AuthorizeClusterSet:         authorizeClusterSet,
+		RecordRequester:             enableWebhook,
+		RepairManagedCluster:        repairManagedCluster,
+		SyncLabels:                  syncLabels,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create claim controller", "controller")
 		os.Exit(1)
@@ -112,6 +206,22 @@ func main() {
 		setupLog.Error(err, "unable to create managed cluster controller", "controller")
 		os.Exit(1)
 	}
+	if enableWebhook {
+		mgr.GetWebhookServer().Register(controller.VALIDATE_PATH, &webhook.Admission{
+			Handler: &controller.ClusterClaimValidator{
+				Client:              mgr.GetClient(),
+				Log:                 ctrl.Log.WithName("webhook").WithName("ClusterClaimValidator"),
+				Decoder:             admission.NewDecoder(mgr.GetScheme()),
+				AuthorizeClusterSet: authorizeClusterSet,
+			},
+		})
+		mgr.GetWebhookServer().Register(controller.MUTATE_PATH, &webhook.Admission{
+			Handler: &controller.ClusterClaimMutator{
+				Log:     ctrl.Log.WithName("webhook").WithName("ClusterClaimMutator"),
+				Decoder: admission.NewDecoder(mgr.GetScheme()),
+			},
+		})
+	}
 	// +kubebuilder:scaffold:builder
 
 	setupLog.Info("starting manager")
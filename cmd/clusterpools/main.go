@@ -40,6 +40,7 @@ func main() {
 	var leaderElectionLeaseDuration time.Duration
 	var leaderElectionRenewDeadline time.Duration
 	var leaderElectionRetryPeriod time.Duration
+	var deleteLabeledSecretsOnly bool
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8383", "The address the metric endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
 		"Enable leader election for controller manager. "+
@@ -69,6 +70,9 @@ func main() {
 		"The duration the clients should wait between attempting acquisition and renewal "+
 			"of a leadership. This is only applicable if leader election is enabled.",
 	)
+	flag.BoolVar(&deleteLabeledSecretsOnly, "delete-labeled-secrets-only", false,
+		"Only delete the secrets of a deleted ClusterPool that are labeled "+
+			"open-cluster-management.io/managed-by=clusterpools.")
 	flag.Parse()
 
 	// To run in debug change zapcore.InfoLevel to zapcore.DebugLevel
@@ -107,10 +111,11 @@ func main() {
 	}
 
 	if err = (&controller.ClusterPoolsReconciler{
-		KubeClient: kubeClient,
-		Client:     mgr.GetClient(),
-		Log:        ctrl.Log.WithName("controller").WithName("ClusterPoolsReconciler"),
-		Scheme:     mgr.GetScheme(),
+		KubeClient:               kubeClient,
+		Client:                   mgr.GetClient(),
+		Log:                      ctrl.Log.WithName("controller").WithName("ClusterPoolsReconciler"),
+		Scheme:                   mgr.GetScheme(),
+		DeleteLabeledSecretsOnly: deleteLabeledSecretsOnly,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller")
 		os.Exit(1)
@@ -0,0 +1,208 @@
+// Copyright Contributors to the Open Cluster Management project.
+
+package clusterlcaims
+
+import (
+	"context"
+	"strings"
+
+	hivev1 "github.com/openshift/hive/apis/hive/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	addonv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	mcv1 "open-cluster-management.io/api/cluster/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// KLUSTERLET_ADDONS overrides, on a ClusterClaim or ClusterPool, the comma separated KlusterletAddonConfig add-ons to enable
+const KLUSTERLET_ADDONS = "cluster.open-cluster-management.io/klusterlet-addons"
+
+// MANAGEDCLUSTER_ADDONS lists, comma separated on a ClusterClaim or ClusterPool, the ManagedClusterAddOns to enable
+const MANAGEDCLUSTER_ADDONS = "cluster.open-cluster-management.io/managedcluster-addons"
+
+// KlusterletAddonConfigGVK is the ACM KlusterletAddonConfig, it is not vendored so unstructured is used
+var KlusterletAddonConfigGVK = schema.GroupVersionKind{
+	Group:   "agent.open-cluster-management.io",
+	Version: "v1",
+	Kind:    "KlusterletAddonConfig",
+}
+
+// KlusterletAddons are the add-ons that can be enabled in a KlusterletAddonConfig spec
+var KlusterletAddons = []string{
+	"applicationManager",
+	"certPolicyController",
+	"iamPolicyController",
+	"policyController",
+	"searchCollector",
+}
+
+// DefaultKlusterletAddons are enabled when neither the controller, the pool nor the claim choose otherwise
+const DefaultKlusterletAddons = "applicationManager,certPolicyController,policyController,searchCollector"
+
+// getClusterPool returns the pool the claim was made from, nil when the pool does not exist
+func getClusterPool(r *ClusterClaimsReconciler, cc *hivev1.ClusterClaim) (*hivev1.ClusterPool, error) {
+	var cp hivev1.ClusterPool
+	if err := r.Get(
+		context.Background(),
+		types.NamespacedName{Namespace: cc.Namespace, Name: cc.Spec.ClusterPoolName}, &cp); err != nil {
+
+		if k8serrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &cp, nil
+}
+
+// getClaimOrPoolAnnotation returns the annotation from the claim, else from its pool
+func getClaimOrPoolAnnotation(r *ClusterClaimsReconciler, cc *hivev1.ClusterClaim, key string) (string, bool, error) {
+	if value, found := cc.Annotations[key]; found {
+		return value, true, nil
+	}
+
+	cp, err := getClusterPool(r, cc)
+	if err != nil || cp == nil {
+		return "", false, err
+	}
+
+	value, found := cp.Annotations[key]
+	return value, found, nil
+}
+
+// splitList splits a comma separated annotation or flag value, dropping blanks
+func splitList(value string) []string {
+	items := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// createKlusterletAddonConfig creates the KlusterletAddonConfig for the claimed cluster, owned by its ManagedCluster
+func createKlusterletAddonConfig(r *ClusterClaimsReconciler, cc *hivev1.ClusterClaim, mc *mcv1.ManagedCluster) error {
+	ctx := context.Background()
+	log := r.Log
+	target := mc.Name
+
+	kac := &unstructured.Unstructured{}
+	kac.SetGroupVersionKind(KlusterletAddonConfigGVK)
+	err := r.Get(ctx, types.NamespacedName{Namespace: target, Name: target}, kac)
+	if err == nil {
+		log.V(DEBUG).Info("KlusterletAddonConfig: " + target + " already exists")
+		return nil
+	} else if !k8serrors.IsNotFound(err) {
+		return err
+	}
+
+	addons := r.KlusterletAddons
+	if value, found, err := getClaimOrPoolAnnotation(r, cc, KLUSTERLET_ADDONS); err != nil {
+		return err
+	} else if found {
+		addons = value
+	}
+
+	enabled := map[string]bool{}
+	for _, addon := range splitList(addons) {
+		enabled[addon] = true
+	}
+
+	spec := map[string]interface{}{
+		"clusterName":      target,
+		"clusterNamespace": target,
+		"clusterLabels": map[string]interface{}{
+			"cloud":  mc.Labels[CLOUD_LABEL],
+			"vendor": mc.Labels[VENDOR_LABEL],
+		},
+	}
+	for _, addon := range KlusterletAddons {
+		spec[addon] = map[string]interface{}{"enabled": enabled[addon]}
+		delete(enabled, addon)
+	}
+	for addon := range enabled {
+		log.V(WARN).Info("Unknown klusterlet add-on: " + addon + " on cluster claim: " + cc.Name)
+	}
+
+	kac = &unstructured.Unstructured{Object: map[string]interface{}{"spec": spec}}
+	kac.SetGroupVersionKind(KlusterletAddonConfigGVK)
+	kac.SetName(target)
+	kac.SetNamespace(target)
+
+	// Garbage collected with the ManagedCluster
+	if err := controllerutil.SetOwnerReference(mc, kac, r.Scheme); err != nil {
+		return err
+	}
+
+	log.V(INFO).Info("Create KlusterletAddonConfig: " + target + " with add-ons: " + addons)
+	if err := r.Create(ctx, kac); err != nil && !k8serrors.IsAlreadyExists(err) {
+		log.V(ERROR).Info("Could not create KlusterletAddonConfig resource: " + target)
+		return err
+	}
+	return nil
+}
+
+// createManagedClusterAddOns creates the ManagedClusterAddOns listed on the claim or its pool in the cluster namespace
+func createManagedClusterAddOns(r *ClusterClaimsReconciler, cc *hivev1.ClusterClaim, mc *mcv1.ManagedCluster) error {
+	ctx := context.Background()
+	log := r.Log
+	target := mc.Name
+
+	value, found, err := getClaimOrPoolAnnotation(r, cc, MANAGEDCLUSTER_ADDONS)
+	if err != nil || !found {
+		return err
+	}
+
+	for _, name := range splitList(value) {
+		addon := &addonv1alpha1.ManagedClusterAddOn{}
+		addon.Name = name
+		addon.Namespace = target
+		addon.Annotations = map[string]string{CLAIM_UID_ANNOTATION: string(cc.UID)}
+
+		if err := controllerutil.SetOwnerReference(mc, addon, r.Scheme); err != nil {
+			return err
+		}
+
+		if err := r.Create(ctx, addon); err != nil {
+			if k8serrors.IsAlreadyExists(err) {
+				log.V(DEBUG).Info("ManagedClusterAddOn: " + name + " already exists for cluster: " + target)
+				continue
+			}
+			log.V(ERROR).Info("Could not create ManagedClusterAddOn: " + name + " for cluster: " + target)
+			return err
+		}
+		log.V(INFO).Info("Created ManagedClusterAddOn: " + name + " for cluster: " + target)
+	}
+	return nil
+}
+
+// deleteManagedClusterAddOns removes the ManagedClusterAddOns that were created for the claim
+func deleteManagedClusterAddOns(r *ClusterClaimsReconciler, cc *hivev1.ClusterClaim) error {
+	ctx := context.Background()
+	target := cc.Spec.Namespace
+
+	var addons addonv1alpha1.ManagedClusterAddOnList
+	if err := r.List(ctx, &addons, &client.ListOptions{Namespace: target}); err != nil {
+		if meta.IsNoMatchError(err) {
+			// The add-on API is not installed on this hub
+			return nil
+		}
+		return err
+	}
+
+	for i := range addons.Items {
+		addon := &addons.Items[i]
+		if uid, found := addon.Annotations[CLAIM_UID_ANNOTATION]; !found || uid != string(cc.UID) {
+			continue
+		}
+		if err := r.Delete(ctx, addon); err != nil && !k8serrors.IsNotFound(err) {
+			return err
+		}
+		r.Log.V(INFO).Info("Deleted ManagedClusterAddOn: " + addon.Name + " for cluster: " + target)
+	}
+	return nil
+}
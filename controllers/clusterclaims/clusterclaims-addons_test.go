@@ -0,0 +1,192 @@
+package clusterlcaims
+
+import (
+	"context"
+	"testing"
+
+	hivev1 "github.com/openshift/hive/apis/hive/v1"
+	"github.com/stretchr/testify/assert"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	addonv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
+	mcv1 "open-cluster-management.io/api/cluster/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	clientfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+func getKlusterletAddonConfig(ccr *ClusterClaimsReconciler) (*unstructured.Unstructured, error) {
+	kac := &unstructured.Unstructured{}
+	kac.SetGroupVersionKind(KlusterletAddonConfigGVK)
+	err := ccr.Client.Get(context.Background(), getNamespaceName(CLUSTER01, CLUSTER01), kac)
+	return kac, err
+}
+
+func addonEnabled(kac *unstructured.Unstructured, addon string) bool {
+	enabled, _, _ := unstructured.NestedBool(kac.Object, "spec", addon, "enabled")
+	return enabled
+}
+
+func TestReconcileClusterClaimsKlusterletAddonConfig(t *testing.T) {
+
+	ctx := context.Background()
+
+	ccr := GetClusterClaimsReconciler()
+	ccr.CreateKlusterletAddonConfig = true
+	ccr.KlusterletAddons = DefaultKlusterletAddons
+
+	ccr.Client.Create(ctx, GetClusterClaim(CC_NAMESPACE, CC_NAME, CLUSTER01), &client.CreateOptions{})
+
+	_, err := ccr.Reconcile(ctx, getRequest())
+	assert.Nil(t, err, "nil, when clusterClaim is found reconcile was successful")
+
+	kac, err := getKlusterletAddonConfig(ccr)
+	assert.Nil(t, err, "nil, when the KlusterletAddonConfig is retrieved")
+
+	assert.True(t, addonEnabled(kac, "applicationManager"), "applicationManager should be enabled")
+	assert.True(t, addonEnabled(kac, "policyController"), "policyController should be enabled")
+	assert.False(t, addonEnabled(kac, "iamPolicyController"), "iamPolicyController should be disabled")
+
+	clusterName, _, _ := unstructured.NestedString(kac.Object, "spec", "clusterName")
+	assert.Equal(t, CLUSTER01, clusterName, "clusterName should equal the cluster")
+
+	owners := kac.GetOwnerReferences()
+	assert.Len(t, owners, 1, "the KlusterletAddonConfig should be owned by the ManagedCluster")
+	assert.Equal(t, "ManagedCluster", owners[0].Kind, "the owner should be the ManagedCluster")
+}
+
+func TestReconcileClusterClaimsKlusterletAddonConfigOverride(t *testing.T) {
+
+	ctx := context.Background()
+
+	ccr := GetClusterClaimsReconciler()
+	ccr.CreateKlusterletAddonConfig = true
+	ccr.KlusterletAddons = DefaultKlusterletAddons
+
+	cc := GetClusterClaim(CC_NAMESPACE, CC_NAME, CLUSTER01)
+	cp := GetClusterPool(CC_NAMESPACE, cc.Spec.ClusterPoolName, nil)
+	cp.Annotations = map[string]string{KLUSTERLET_ADDONS: "searchCollector"}
+	ccr.Client.Create(ctx, cp, &client.CreateOptions{})
+	ccr.Client.Create(ctx, cc, &client.CreateOptions{})
+
+	_, err := ccr.Reconcile(ctx, getRequest())
+	assert.Nil(t, err, "nil, when clusterClaim is found reconcile was successful")
+
+	kac, err := getKlusterletAddonConfig(ccr)
+	assert.Nil(t, err, "nil, when the KlusterletAddonConfig is retrieved")
+	assert.True(t, addonEnabled(kac, "searchCollector"), "searchCollector should be enabled by the pool")
+	assert.False(t, addonEnabled(kac, "applicationManager"), "applicationManager should be disabled by the pool")
+
+	// The claim takes precedence over the pool
+	cc.Annotations = map[string]string{KLUSTERLET_ADDONS: "policyController"}
+	enabled, found, err := getClaimOrPoolAnnotation(ccr, cc, KLUSTERLET_ADDONS)
+	assert.Nil(t, err, "nil, when the annotation is looked up")
+	assert.True(t, found, "the claim annotation should be found")
+	assert.Equal(t, "policyController", enabled, "the claim annotation should take precedence")
+}
+
+func TestReconcileClusterClaimsNoKlusterletAddonConfig(t *testing.T) {
+
+	ctx := context.Background()
+
+	ccr := GetClusterClaimsReconciler()
+
+	ccr.Client.Create(ctx, GetClusterClaim(CC_NAMESPACE, CC_NAME, CLUSTER01), &client.CreateOptions{})
+
+	_, err := ccr.Reconcile(ctx, getRequest())
+	assert.Nil(t, err, "nil, when clusterClaim is found reconcile was successful")
+
+	_, err = getKlusterletAddonConfig(ccr)
+	assert.NotNil(t, err, "not nil, the KlusterletAddonConfig is only created when enabled")
+}
+
+func TestReconcileClusterClaimsManagedClusterAddOns(t *testing.T) {
+
+	ctx := context.Background()
+
+	ccr := GetClusterClaimsReconciler()
+
+	cc := GetClusterClaim(CC_NAMESPACE, CC_NAME, CLUSTER01)
+	cc.UID = "6e8a5e4f-1d4b-4e57-9b6f-0f2a3c4d5e6f"
+	cc.Annotations = map[string]string{MANAGEDCLUSTER_ADDONS: "work-manager, config-policy-controller"}
+	ccr.Client.Create(ctx, cc, &client.CreateOptions{})
+
+	// Created by someone else, must survive the claim deletion
+	ccr.Client.Create(ctx, &addonv1alpha1.ManagedClusterAddOn{
+		ObjectMeta: v1.ObjectMeta{Name: "observability-controller", Namespace: CLUSTER01},
+	}, &client.CreateOptions{})
+
+	_, err := ccr.Reconcile(ctx, getRequest())
+	assert.Nil(t, err, "nil, when clusterClaim is found reconcile was successful")
+
+	var addon addonv1alpha1.ManagedClusterAddOn
+	err = ccr.Client.Get(ctx, getNamespaceName(CLUSTER01, "work-manager"), &addon)
+	assert.Nil(t, err, "nil, when the work-manager add-on is created")
+	assert.Len(t, addon.OwnerReferences, 1, "the add-on should be owned by the ManagedCluster")
+
+	err = ccr.Client.Get(ctx, getNamespaceName(CLUSTER01, "config-policy-controller"), &addon)
+	assert.Nil(t, err, "nil, when the config-policy-controller add-on is created")
+
+	err = ccr.Client.Get(ctx, getNamespaceName(CC_NAMESPACE, CC_NAME), cc)
+	assert.Nil(t, err, "nil, when clusterClaim is retrieved")
+	err = ccr.Client.Delete(ctx, cc)
+	assert.Nil(t, err, "nil, when clusterClaim is deleted")
+
+	_, err = ccr.Reconcile(ctx, getRequest())
+	assert.Nil(t, err, "nil, when the claim deletion is reconciled")
+
+	err = ccr.Client.Get(ctx, getNamespaceName(CLUSTER01, "work-manager"), &addon)
+	assert.NotNil(t, err, "not nil, the work-manager add-on is removed with the claim")
+
+	err = ccr.Client.Get(ctx, getNamespaceName(CLUSTER01, "observability-controller"), &addon)
+	assert.Nil(t, err, "nil, add-ons not created for the claim are kept")
+}
+
+func TestReconcileClusterClaimsManagedClusterAddOnsFromPool(t *testing.T) {
+
+	ctx := context.Background()
+
+	ccr := GetClusterClaimsReconciler()
+
+	cc := GetClusterClaim(CC_NAMESPACE, CC_NAME, CLUSTER01)
+	cp := GetClusterPool(CC_NAMESPACE, cc.Spec.ClusterPoolName, nil)
+	cp.Annotations = map[string]string{MANAGEDCLUSTER_ADDONS: "work-manager"}
+	ccr.Client.Create(ctx, cp, &client.CreateOptions{})
+	ccr.Client.Create(ctx, cc, &client.CreateOptions{})
+
+	_, err := ccr.Reconcile(ctx, getRequest())
+	assert.Nil(t, err, "nil, when clusterClaim is found reconcile was successful")
+
+	var addon addonv1alpha1.ManagedClusterAddOn
+	err = ccr.Client.Get(ctx, getNamespaceName(CLUSTER01, "work-manager"), &addon)
+	assert.Nil(t, err, "nil, when the pool add-on is created")
+}
+
+func TestReconcileClusterClaimsKlusterletAddonConfigCacheLag(t *testing.T) {
+
+	ctx := context.Background()
+
+	ccr := GetClusterClaimsReconciler()
+	ccr.CreateKlusterletAddonConfig = true
+	ccr.KlusterletAddons = DefaultKlusterletAddons
+
+	// The ManagedCluster just created is not in the cache yet
+	ccr.Client = clientfake.NewClientBuilder().WithScheme(s).WithStatusSubresource(&hivev1.ClusterClaim{}).
+		WithInterceptorFuncs(interceptor.Funcs{
+			Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+				if _, ok := obj.(*mcv1.ManagedCluster); ok {
+					return k8serrors.NewNotFound(mcv1.Resource("managedclusters"), key.Name)
+				}
+				return c.Get(ctx, key, obj, opts...)
+			},
+		}).Build()
+
+	ccr.Client.Create(ctx, GetClusterClaim(CC_NAMESPACE, CC_NAME, CLUSTER01), &client.CreateOptions{})
+
+	_, err := ccr.Reconcile(ctx, getRequest())
+	assert.Nil(t, err, "nil, the created ManagedCluster is used without reading it back")
+
+	_, err = getKlusterletAddonConfig(ccr)
+	assert.Nil(t, err, "nil, when the KlusterletAddonConfig is retrieved")
+}
@@ -0,0 +1,112 @@
+// Copyright Contributors to the Open Cluster Management project.
+
+package clusterlcaims
+
+import (
+	"context"
+	"encoding/json"
+
+	hivev1 "github.com/openshift/hive/apis/hive/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	mcv1 "open-cluster-management.io/api/cluster/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// REQUESTER_ANNOTATION holds the JSON encoded authentication/v1 UserInfo of the user that created the claim,
+// it is written by the ClusterClaimMutator webhook
+const REQUESTER_ANNOTATION = "clusterclaims-controller.open-cluster-management.io/requester"
+
+// getClaimRequester returns the requester recorded on the claim, nil when there is none or it is not trusted.
+// Without the webhook anyone creating a claim can write the annotation. The claim subjects are never used, they
+// are chosen by the claim author too.
+func getClaimRequester(cc *hivev1.ClusterClaim, trustRequester bool) *authenticationv1.UserInfo {
+	value, found := cc.Annotations[REQUESTER_ANNOTATION]
+	if !found || !trustRequester {
+		return nil
+	}
+
+	var requester authenticationv1.UserInfo
+	if err := json.Unmarshal([]byte(value), &requester); err != nil || requester.Username == "" {
+		return nil
+	}
+	return &requester
+}
+
+// authorizeClusterSet checks with a SubjectAccessReview that the claim requester may join the ManagedClusterSet.
+// A ManagedCluster already in the set is not joined again, so claims imported before the requester was recorded
+// keep working. Otherwise a claim without a trusted requester is denied. The reason is set when denied.
+func authorizeClusterSet(r *ClusterClaimsReconciler, cc *hivev1.ClusterClaim, clusterSet string) (bool, string, error) {
+	var mc mcv1.ManagedCluster
+	if err := r.Get(context.Background(), types.NamespacedName{Name: cc.Spec.Namespace}, &mc); err == nil {
+		if mc.Labels[ClusterSetLabel] == clusterSet {
+			return true, "", nil
+		}
+	} else if !k8serrors.IsNotFound(err) {
+		return false, "", err
+	}
+
+	requester := getClaimRequester(cc, r.RecordRequester)
+	if requester == nil {
+		return false, "no trusted requester recorded on the claim to authorize joining ManagedClusterSet " + clusterSet, nil
+	}
+
+	allowed, err := reviewClusterSetJoin(context.Background(), r.Client, *requester, clusterSet)
+	if err != nil {
+		return false, "", err
+	}
+	if !allowed {
+		return false, requester.Username + " may not join ManagedClusterSet " + clusterSet, nil
+	}
+	return true, "", nil
+}
+
+// reviewClusterSetJoin creates a SubjectAccessReview for the user joining the ManagedClusterSet
+func reviewClusterSetJoin(
+	ctx context.Context,
+	c client.Client,
+	requester authenticationv1.UserInfo,
+	clusterSet string) (bool, error) {
+
+	extra := map[string]authorizationv1.ExtraValue{}
+	for key, value := range requester.Extra {
+		extra[key] = authorizationv1.ExtraValue(value)
+	}
+
+	sar := &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:   requester.Username,
+			UID:    requester.UID,
+			Groups: requester.Groups,
+			Extra:  extra,
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Group:       "cluster.open-cluster-management.io",
+				Resource:    "managedclustersets",
+				Subresource: "join",
+				Name:        clusterSet,
+				Verb:        "create",
+			},
+		},
+	}
+	if err := c.Create(ctx, sar); err != nil {
+		return false, err
+	}
+	return sar.Status.Allowed, nil
+}
+
+// reportClusterSetDenied records the denied ManagedClusterSet join on the claim, as an event and in its conditions
+func reportClusterSetDenied(r *ClusterClaimsReconciler, cc *hivev1.ClusterClaim, reason string) error {
+	r.Log.V(WARN).Info("Cluster claim: " + cc.Name + ", " + reason)
+	recordEvent(r, cc, corev1.EventTypeWarning, "ClusterSetJoinDenied", reason)
+
+	updated := cc.DeepCopy()
+	setClaimCondition(updated, ClusterSetAssignedCondition, corev1.ConditionFalse, "ClusterSetJoinDenied", reason)
+	if equality.Semantic.DeepEqual(cc.Status, updated.Status) {
+		return nil
+	}
+	return r.Status().Patch(context.Background(), updated, client.MergeFromWithOptions(cc, client.MergeFromWithOptimisticLock{}))
+}
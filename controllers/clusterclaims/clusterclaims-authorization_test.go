@@ -0,0 +1,209 @@
+package clusterlcaims
+
+import (
+	"context"
+	"testing"
+
+	hivev1 "github.com/openshift/hive/apis/hive/v1"
+	"github.com/stretchr/testify/assert"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	mcv1 "open-cluster-management.io/api/cluster/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	clientfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+// getAuthorizingReconciler answers SubjectAccessReviews with the users allowed to join each ManagedClusterSet
+func getAuthorizingReconciler(members map[string][]string) *ClusterClaimsReconciler {
+	ccr := GetClusterClaimsReconciler()
+	ccr.AuthorizeClusterSet = true
+	ccr.RecordRequester = true
+	ccr.Client = clientfake.NewClientBuilder().WithScheme(s).WithStatusSubresource(&hivev1.ClusterClaim{}).
+		WithInterceptorFuncs(interceptor.Funcs{
+			Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+				sar, ok := obj.(*authorizationv1.SubjectAccessReview)
+				if !ok {
+					return c.Create(ctx, obj, opts...)
+				}
+				for _, user := range members[sar.Spec.ResourceAttributes.Name] {
+					sar.Status.Allowed = sar.Status.Allowed || user == sar.Spec.User
+				}
+				return nil
+			},
+		}).Build()
+	return ccr
+}
+
+func TestGetClaimRequester(t *testing.T) {
+
+	cc := GetClusterClaim(CC_NAMESPACE, CC_NAME, CLUSTER01)
+	assert.Nil(t, getClaimRequester(cc, true), "no requester without annotation")
+
+	// The subjects are written by the claim author, they are not a requester
+	cc.Spec.Subjects = []rbacv1.Subject{{Kind: rbacv1.GroupKind, Name: "system:masters"}}
+	assert.Nil(t, getClaimRequester(cc, true), "no requester from the subjects")
+
+	cc.Annotations = map[string]string{REQUESTER_ANNOTATION: `{"username":"bob","groups":["system:authenticated"]}`}
+	requester := getClaimRequester(cc, true)
+	if assert.NotNil(t, requester, "recorded requester") {
+		assert.Equal(t, "bob", requester.Username, "recorded requester")
+	}
+
+	assert.Nil(t, getClaimRequester(cc, false), "the requester is not trusted without the webhook")
+
+	cc.Annotations[REQUESTER_ANNOTATION] = "not json"
+	assert.Nil(t, getClaimRequester(cc, true), "no requester from an invalid annotation")
+}
+
+func TestReconcileClusterClaimsClusterSetAuthorized(t *testing.T) {
+
+	ctx := context.Background()
+
+	ccr := getAuthorizingReconciler(map[string][]string{"team-set": {"alice"}})
+
+	cc := GetClusterClaim(CC_NAMESPACE, CC_NAME, CLUSTER01)
+	cc.Labels[ClusterSetLabel] = "team-set"
+	cc.Annotations = map[string]string{REQUESTER_ANNOTATION: `{"username":"alice"}`}
+	ccr.Client.Create(ctx, cc, &client.CreateOptions{})
+
+	_, err := ccr.Reconcile(ctx, getRequest())
+	assert.Nil(t, err, "nil, when clusterClaim is found reconcile was successful")
+
+	var mc mcv1.ManagedCluster
+	err = ccr.Client.Get(ctx, getNamespaceName("", CLUSTER01), &mc)
+	assert.Nil(t, err, "nil, when managedCluster resource is retrieved")
+	assert.Equal(t, "team-set", mc.Labels[ClusterSetLabel], "alice may join team-set")
+}
+
+func TestReconcileClusterClaimsClusterSetDenied(t *testing.T) {
+
+	ctx := context.Background()
+
+	ccr := getAuthorizingReconciler(map[string][]string{"admin-set": {"admin"}})
+
+	cc := GetClusterClaim(CC_NAMESPACE, CC_NAME, CLUSTER01)
+	cc.Labels[ClusterSetLabel] = "admin-set"
+	cc.Annotations = map[string]string{REQUESTER_ANNOTATION: `{"username":"mallory"}`}
+	cc.Spec.Subjects = []rbacv1.Subject{{Kind: rbacv1.UserKind, Name: "admin"}}
+	ccr.Client.Create(ctx, cc, &client.CreateOptions{})
+
+	res, err := ccr.Reconcile(ctx, getRequest())
+	assert.Nil(t, err, "nil, when clusterClaim is found reconcile was successful")
+	assert.Equal(t, WAITING_REQUEUE, res.RequeueAfter, "requeue, the permission may be granted later")
+
+	var mc mcv1.ManagedCluster
+	err = ccr.Client.Get(ctx, getNamespaceName("", CLUSTER01), &mc)
+	assert.True(t, k8serrors.IsNotFound(err), "no managedCluster when the requester may not join the set")
+
+	err = ccr.Client.Get(ctx, getNamespaceName(CC_NAMESPACE, CC_NAME), cc)
+	assert.Nil(t, err, "nil, when clusterClaim is retrieved")
+	cond := getClaimCondition(cc, ClusterSetAssignedCondition)
+	if assert.NotNil(t, cond, "clusterset condition is set") {
+		assert.Equal(t, corev1.ConditionFalse, cond.Status, "clusterset is not assigned")
+		assert.Equal(t, "ClusterSetJoinDenied", cond.Reason, "denial is reported")
+		assert.Contains(t, cond.Message, "mallory", "the requester is named")
+	}
+}
+
+func TestReconcileClusterClaimsPoolClusterSetNotAuthorized(t *testing.T) {
+
+	ctx := context.Background()
+
+	ccr := getAuthorizingReconciler(map[string][]string{})
+
+	// The pool set is chosen by the pool author, it is not checked against the claim requester
+	cc := GetClusterClaim(CC_NAMESPACE, CC_NAME, CLUSTER01)
+	cp := GetClusterPool(CC_NAMESPACE, cc.Spec.ClusterPoolName, map[string]string{ClusterSetLabel: "pool-set"})
+	ccr.Client.Create(ctx, cp, &client.CreateOptions{})
+	ccr.Client.Create(ctx, cc, &client.CreateOptions{})
+
+	_, err := ccr.Reconcile(ctx, getRequest())
+	assert.Nil(t, err, "nil, when clusterClaim is found reconcile was successful")
+
+	var mc mcv1.ManagedCluster
+	err = ccr.Client.Get(ctx, getNamespaceName("", CLUSTER01), &mc)
+	assert.Nil(t, err, "nil, when managedCluster resource is retrieved")
+	assert.Equal(t, "pool-set", mc.Labels[ClusterSetLabel], "the pool set is joined")
+}
+
+func TestReconcileClusterClaimsClusterSetNoRequester(t *testing.T) {
+
+	ctx := context.Background()
+
+	ccr := getAuthorizingReconciler(map[string][]string{"admin-set": {"admin"}})
+
+	// The claim author lists an admin as subject, it is not trusted to join the set
+	cc := GetClusterClaim(CC_NAMESPACE, CC_NAME, CLUSTER01)
+	cc.Labels[ClusterSetLabel] = "admin-set"
+	cc.Spec.Subjects = []rbacv1.Subject{{Kind: rbacv1.UserKind, Name: "admin"}}
+	ccr.Client.Create(ctx, cc, &client.CreateOptions{})
+
+	res, err := ccr.Reconcile(ctx, getRequest())
+	assert.Nil(t, err, "nil, when clusterClaim is found reconcile was successful")
+	assert.Equal(t, WAITING_REQUEUE, res.RequeueAfter, "requeue, the claim is denied")
+
+	var mc mcv1.ManagedCluster
+	err = ccr.Client.Get(ctx, getNamespaceName("", CLUSTER01), &mc)
+	assert.True(t, k8serrors.IsNotFound(err), "no managedCluster without a trusted requester")
+
+	// The same claim is denied when the requester annotation is not trusted
+	ccr.RecordRequester = false
+	err = ccr.Client.Get(ctx, getNamespaceName(CC_NAMESPACE, CC_NAME), cc)
+	assert.Nil(t, err, "nil, when clusterClaim is retrieved")
+	cc.Annotations = map[string]string{REQUESTER_ANNOTATION: `{"username":"admin"}`}
+	err = ccr.Client.Update(ctx, cc)
+	assert.Nil(t, err, "nil, when clusterClaim is updated")
+
+	_, err = ccr.Reconcile(ctx, getRequest())
+	assert.Nil(t, err, "nil, when clusterClaim is found reconcile was successful")
+	err = ccr.Client.Get(ctx, getNamespaceName("", CLUSTER01), &mc)
+	assert.True(t, k8serrors.IsNotFound(err), "no managedCluster with an untrusted requester annotation")
+}
+
+func TestReconcileClusterClaimsClusterSetImportedWithoutRequester(t *testing.T) {
+
+	ctx := context.Background()
+
+	ccr := getAuthorizingReconciler(map[string][]string{})
+	ccr.SyncLabels = true
+
+	// Imported before the webhook recorded requesters, its ManagedCluster is already in the set
+	cc := GetClusterClaim(CC_NAMESPACE, CC_NAME, CLUSTER01)
+	cc.Labels[ClusterSetLabel] = "team-set"
+	cc.Annotations = map[string]string{IMPORTED: "true"}
+	ccr.Client.Create(ctx, cc, &client.CreateOptions{})
+
+	mc := &mcv1.ManagedCluster{ObjectMeta: v1.ObjectMeta{
+		Name:        CLUSTER01,
+		Labels:      map[string]string{ClusterSetLabel: "team-set", "usage": "production"},
+		Annotations: map[string]string{MANAGED_LABELS: "usage"},
+	}}
+	ccr.Client.Create(ctx, mc, &client.CreateOptions{})
+
+	cc.Labels["usage"] = "testing"
+	err := ccr.Client.Update(ctx, cc)
+	assert.Nil(t, err, "nil, when clusterClaim is updated")
+
+	res, err := ccr.Reconcile(ctx, getRequest())
+	assert.Nil(t, err, "nil, when clusterClaim is found reconcile was successful")
+	assert.Zero(t, res.RequeueAfter, "no requeue, the set is already joined")
+
+	err = ccr.Client.Get(ctx, getNamespaceName("", CLUSTER01), mc)
+	assert.Nil(t, err, "nil, when managedCluster resource is retrieved")
+	assert.Equal(t, "testing", mc.Labels["usage"], "the labels are still synced")
+
+	err = ccr.Client.Get(ctx, getNamespaceName(CC_NAMESPACE, CC_NAME), cc)
+	assert.Nil(t, err, "nil, when clusterClaim is retrieved")
+	cond := getClaimCondition(cc, ClusterSetAssignedCondition)
+	if assert.NotNil(t, cond, "clusterset condition is set") {
+		assert.Equal(t, corev1.ConditionTrue, cond.Status, "the clusterset is assigned")
+	}
+
+	recorder := ccr.Recorder.(*record.FakeRecorder)
+	assert.Len(t, recorder.Events, 0, "no denial event is recorded")
+}
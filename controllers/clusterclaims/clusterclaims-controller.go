@@ -4,20 +4,28 @@ package clusterlcaims
 
 import (
 	"context"
+	"fmt"
 	"strings"
+	"time"
 
 	"github.com/go-logr/logr"
 	hivev1 "github.com/openshift/hive/apis/hive/v1"
+	corev1 "k8s.io/api/core/v1"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/tools/record"
 	mcv1 "open-cluster-management.io/api/cluster/v1"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
 const DEBUG = 1
@@ -26,13 +34,79 @@ const WARN = -1
 const ERROR = -2
 const FINALIZER = "clusterclaims-controller.open-cluster-management.io/cleanup"
 const CREATECM = "cluster.open-cluster-management.io/createmanagedcluster"
+
+// IMPORTED marks a claim whose ManagedCluster was created by the controller, CREATECM is left to the user
+const IMPORTED = "clusterclaims-controller.open-cluster-management.io/imported"
 const ClusterSetLabel = "cluster.open-cluster-management.io/clusterset"
 
+// Claim ownership, set on the ManagedCluster created for a claim
+const CLAIM_NAME_LABEL = "clusterclaims-controller.open-cluster-management.io/claim-name"
+const CLAIM_NAMESPACE_LABEL = "clusterclaims-controller.open-cluster-management.io/claim-namespace"
+const CLUSTERPOOL_LABEL = "clusterclaims-controller.open-cluster-management.io/clusterpool"
+const CLAIM_UID_ANNOTATION = "clusterclaims-controller.open-cluster-management.io/claim-uid"
+
+// DELETION_POLICY selects what happens to the ManagedCluster when the claim is deleted
+const DELETION_POLICY = "cluster.open-cluster-management.io/managedcluster-deletion-policy"
+
+const (
+	// DeletionPolicyDelete deletes the ManagedCluster with the claim
+	DeletionPolicyDelete = "Delete"
+	// DeletionPolicyOrphan leaves the ManagedCluster untouched
+	DeletionPolicyOrphan = "Orphan"
+	// DeletionPolicyDetach keeps the ManagedCluster, but the hub no longer accepts the klusterlet
+	DeletionPolicyDetach = "Detach"
+)
+
+// PROVISIONER is maintained by the ManagedClusterReconciler on ManagedClusters that came from a claim
+const PROVISIONER = "cluster.open-cluster-management.io/provisioner"
+
+// WAITING_REQUEUE is the safety net interval for claims that have not been assigned a cluster yet
+const WAITING_REQUEUE = 5 * time.Minute
+
+// DELETE_REQUEUE is how often a deleting claim checks whether its ManagedCluster is gone
+const DELETE_REQUEUE = 30 * time.Second
+
 // ClusterClaimsReconciler reconciles a clusterClaim
 type ClusterClaimsReconciler struct {
 	client.Client
 	Log    logr.Logger
 	Scheme *runtime.Scheme
+
+	// Recorder emits events on the claim, optional
+	Recorder record.EventRecorder
+
+	// DeleteTimeout bounds how long the claim finalizer waits for the ManagedCluster to be removed, 0 waits forever
+	DeleteTimeout time.Duration
+
+	// DeletionPolicy is the default for claims without the deletion policy annotation, Delete when empty
+	DeletionPolicy string
+
+	// CreateKlusterletAddonConfig creates a KlusterletAddonConfig with the ManagedCluster
+	CreateKlusterletAddonConfig bool
+
+	// KlusterletAddons are the comma separated add-ons enabled by default in the KlusterletAddonConfig
+	KlusterletAddons string
+
+	// DerivedLabels maps cluster metadata fields to the label keys they are added as, see ParseDerivedLabels
+	DerivedLabels map[string]string
+
+	// LabelRules filter and rewrite the labels copied from the claim to the ManagedCluster, all are copied when nil
+	LabelRules *LabelRules
+
+	// PropagateClusterSet moves imported clusters when the clusterset label of their pool changes
+	PropagateClusterSet bool
+
+	// AuthorizeClusterSet only joins the ManagedClusterSet set on a claim when the claim requester may join it
+	AuthorizeClusterSet bool
+
+	// RecordRequester is set when the ClusterClaimMutator webhook records the requester annotation, so it can be trusted
+	RecordRequester bool
+
+	// RepairManagedCluster re-creates the ManagedCluster of an imported claim when it is removed out-of-band
+	RepairManagedCluster bool
+
+	// SyncLabels keeps the claim labels on the ManagedCluster up to date after import
+	SyncLabels bool
 }
 
 func (r *ClusterClaimsReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
@@ -50,51 +124,128 @@ func (r *ClusterClaimsReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 	if target == "" {
 		log.V(WARN).Info("Waiting for cluster claim " + cc.Name + " to complete")
 
-		// Requeue
-		return ctrl.Result{}, nil
+		// The ClusterDeployment watch picks up the assignment, requeue in case that event is missed
+		return ctrl.Result{RequeueAfter: WAITING_REQUEUE}, nil
 	}
 	log.V(INFO).Info("Reconcile cluster: " + target + " for cluster claim: " + cc.Name)
 
 	// Delete the ManagedCluster
 	if cc.DeletionTimestamp != nil {
-		if err := deleteResources(r, target); err != nil {
+		deleted, err := deleteResources(r, &cc)
+		if err != nil {
 			return ctrl.Result{}, err
 		}
 
+		if !deleted {
+			if r.DeleteTimeout == 0 || time.Since(cc.DeletionTimestamp.Time) < r.DeleteTimeout {
+				log.V(INFO).Info("Waiting for ManagedCluster " + target + " to be deleted")
+				return ctrl.Result{RequeueAfter: DELETE_REQUEUE}, nil
+			}
+
+			msg := "Timed out after " + r.DeleteTimeout.String() + " waiting for ManagedCluster " + target + " to be deleted"
+			log.V(WARN).Info(msg)
+			recordEvent(r, &cc, corev1.EventTypeWarning, "ManagedClusterDeleteTimeout", msg)
+		}
+
 		return ctrl.Result{}, removeFinalizer(r, &cc)
 	}
 
+	if err := migrateImportedMarker(r, &cc); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// The clusterset chosen on the claim itself, rather than inherited from the pool, is joined on behalf of the requester
+	claimClusterSet := cc.Labels[ClusterSetLabel]
+
+	// Merge the pool default labels, the claim labels take precedence
+	if err := setPoolDefaultLabels(r, &cc); err != nil {
+		return ctrl.Result{}, err
+	}
+
 	// Get the region for a cloud provider and add it to the cc.Labels
 	if err := setRegion(r, &cc); err != nil {
 		return ctrl.Result{}, err
 	}
 
+	if err := setDerivedLabels(r, &cc); err != nil {
+		return ctrl.Result{}, err
+	}
+
 	if err := setClusterSetLabel(r, &cc); err != nil {
 		return ctrl.Result{}, err
 	}
 
+	if r.AuthorizeClusterSet && claimClusterSet != "" {
+		allowed, reason, err := authorizeClusterSet(r, &cc, claimClusterSet)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if !allowed {
+			// Permissions may be granted later
+			return ctrl.Result{RequeueAfter: WAITING_REQUEUE}, reportClusterSetDenied(r, &cc, reason)
+		}
+	}
+
 	// Do not exit till this point when importmanagedcluster=false, so deletion will work properly if manually imported
-	if len(cc.Annotations) > 0 {
-		aValue, found := cc.Annotations[CREATECM]
-		if found && strings.ToLower(aValue) == "false" {
+	imported := cc.Annotations[IMPORTED] == "true"
+	optOut := strings.ToLower(cc.Annotations[CREATECM]) == "false"
+	if imported || optOut {
+		repair := false
+		if imported && !optOut && r.RepairManagedCluster {
+			var err error
+			if repair, err = needsManagedClusterRepair(r, &cc); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		if repair {
+			msg := "ManagedCluster " + target + " was removed, re-creating it for the cluster claim"
+			log.V(WARN).Info(msg)
+			recordEvent(r, &cc, corev1.EventTypeWarning, "ManagedClusterRecreated", msg)
+		} else {
 			log.V(WARN).Info("Skip creation of managedCluster")
-			return ctrl.Result{}, nil
+			// A claim that opted out after import leaves its ManagedCluster alone
+			if r.SyncLabels && !optOut {
+				if err := syncManagedClusterLabels(r, &cc); err != nil {
+					return ctrl.Result{}, err
+				}
+			}
+			if r.PropagateClusterSet && !optOut {
+				if err := syncManagedClusterSet(r, &cc); err != nil {
+					return ctrl.Result{}, err
+				}
+			}
+			return ctrl.Result{}, syncClaimConditions(r, &cc)
 		}
 	}
 
 	// ManagedCluster
-	if res, err := createManagedCluster(r, cc.Name, target, cc.Labels); err != nil {
+	mc, res, err := createManagedCluster(r, &cc, target, cc.Labels)
+	if err != nil {
 		return res, err
 	}
 
+	if r.CreateKlusterletAddonConfig {
+		if err := createKlusterletAddonConfig(r, &cc, mc); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	if err := createManagedClusterAddOns(r, &cc, mc); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if err := syncClaimConditions(r, &cc); err != nil {
+		return ctrl.Result{}, err
+	}
+
 	// Take the patch base BEFORE modifying the object so that annotation
 	// and finalizer changes are both captured in the merge patch.
 	patch := client.MergeFrom(cc.DeepCopy())
 
 	if len(cc.Annotations) > 0 {
-		cc.Annotations[CREATECM] = "false"
+		cc.Annotations[IMPORTED] = "true"
 	} else {
-		cc.ObjectMeta.Annotations = map[string]string{CREATECM: "false"}
+		cc.ObjectMeta.Annotations = map[string]string{IMPORTED: "true"}
 	}
 
 	controllerutil.AddFinalizer(&cc, FINALIZER)
@@ -103,7 +254,7 @@ func (r *ClusterClaimsReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 }
 
 func (r *ClusterClaimsReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
+	b := ctrl.NewControllerManagedBy(mgr).
 		For(&hivev1.ClusterClaim{}).WithEventFilter(predicate.Funcs{
 		CreateFunc: func(e event.CreateEvent) bool {
 			return true
@@ -114,16 +265,85 @@ func (r *ClusterClaimsReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		DeleteFunc: func(e event.DeleteEvent) bool {
 			return true
 		},
-	}).WithOptions(controller.Options{
+	}).Watches(&mcv1.ManagedCluster{}, handler.EnqueueRequestsFromMapFunc(
+		func(ctx context.Context, mc client.Object) []reconcile.Request {
+			return claimRequestsForManagedCluster(r, mc)
+		})).Watches(&hivev1.ClusterDeployment{}, handler.EnqueueRequestsFromMapFunc(
+		func(ctx context.Context, cd client.Object) []reconcile.Request {
+			return claimRequestsForClusterDeployment(cd)
+		}))
+
+	if r.PropagateClusterSet {
+		b = b.Watches(&hivev1.ClusterPool{}, handler.EnqueueRequestsFromMapFunc(
+			func(ctx context.Context, cp client.Object) []reconcile.Request {
+				return claimRequestsForClusterPool(r, cp)
+			}), builder.WithPredicates(predicate.Funcs{
+			UpdateFunc: func(e event.UpdateEvent) bool {
+				return e.ObjectOld.GetLabels()[ClusterSetLabel] != e.ObjectNew.GetLabels()[ClusterSetLabel]
+			},
+		}))
+	}
+
+	return b.WithOptions(controller.Options{
 		MaxConcurrentReconciles: 1, // This is the default
 	}).Complete(r)
 }
 
+// claimRequestsForClusterPool maps a ClusterPool to the ClusterClaims made from it
+func claimRequestsForClusterPool(r *ClusterClaimsReconciler, cp client.Object) []reconcile.Request {
+	var claims hivev1.ClusterClaimList
+	if err := r.List(context.Background(), &claims, &client.ListOptions{Namespace: cp.GetNamespace()}); err != nil {
+		r.Log.V(ERROR).Info("Could not list cluster claims for cluster pool: " + cp.GetName())
+		return nil
+	}
+
+	requests := []reconcile.Request{}
+	for _, cc := range claims.Items {
+		if cc.Spec.ClusterPoolName != cp.GetName() {
+			continue
+		}
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: cc.Namespace, Name: cc.Name},
+		})
+	}
+	return requests
+}
+
+// claimRequestsForManagedCluster maps a ManagedCluster to the ClusterClaim that claimed it, through
+// the pool reference on the ClusterDeployment of the same name
+func claimRequestsForManagedCluster(r *ClusterClaimsReconciler, mc client.Object) []reconcile.Request {
+	var cd hivev1.ClusterDeployment
+	if err := r.Get(
+		context.Background(),
+		types.NamespacedName{Namespace: mc.GetName(), Name: mc.GetName()}, &cd); err != nil {
+
+		return nil
+	}
+
+	return claimRequestsForClusterDeployment(&cd)
+}
+
+// claimRequestsForClusterDeployment maps a ClusterDeployment to the ClusterClaim it is assigned to
+func claimRequestsForClusterDeployment(obj client.Object) []reconcile.Request {
+	cd, ok := obj.(*hivev1.ClusterDeployment)
+	if !ok || cd.Spec.ClusterPoolRef == nil || cd.Spec.ClusterPoolRef.ClaimName == "" {
+		return nil
+	}
+
+	return []reconcile.Request{{
+		NamespacedName: types.NamespacedName{
+			Namespace: cd.Spec.ClusterPoolRef.Namespace,
+			Name:      cd.Spec.ClusterPoolRef.ClaimName,
+		},
+	}}
+}
+
+// createManagedCluster creates the ManagedCluster for the claim, it returns the ManagedCluster created or found
 func createManagedCluster(
 	r *ClusterClaimsReconciler,
-	claimName string,
+	cc *hivev1.ClusterClaim,
 	target string,
-	labels map[string]string) (ctrl.Result, error) {
+	labels map[string]string) (*mcv1.ManagedCluster, ctrl.Result, error) {
 
 	log := r.Log
 	ctx := context.Background()
@@ -138,16 +358,32 @@ func createManagedCluster(
 
 		// Build the labels
 		newLabels := map[string]string{}
+		managedLabels := map[string]bool{}
 		if labels != nil {
-			for key, val := range labels {
+			for key, val := range filterLabels(r, labels) {
 				log.V(DEBUG).Info("Copy label: " + key)
 				newLabels[key] = val
+				managedLabels[key] = true
 			}
 		}
 
-		//TODO maintain label for claim
-		newLabels["vendor"] = "OpenShift"  // This is always true
-		newLabels["cloud"] = "auto-detect" //This is used to detect cloud provider, like: GCP,AWS
+		platform, err := getClaimPlatform(r, cc)
+		if err != nil {
+			return nil, ctrl.Result{}, err
+		}
+		newLabels[VENDOR_LABEL] = VENDOR_OPENSHIFT // This is always true
+		newLabels[CLOUD_LABEL] = getCloud(platform)
+		delete(managedLabels, VENDOR_LABEL)
+		delete(managedLabels, CLOUD_LABEL)
+
+		// The clusterset is only moved by syncManagedClusterSet, with --propagate-clusterset
+		delete(managedLabels, ClusterSetLabel)
+
+		// Record the labels that came from the claim, so they can be kept in sync later
+		setManagedLabelKeys(&mc, managedLabels)
+
+		// Identify the claim the ManagedCluster was created for
+		setClaimOwnership(&mc, newLabels, cc)
 
 		// Add region lookup. It is a label on the ClusterDeployment or ClusterPool
 		mc.ObjectMeta.Labels = newLabels
@@ -155,18 +391,76 @@ func createManagedCluster(
 		if err = r.Create(ctx, &mc, &client.CreateOptions{}); err != nil {
 
 			log.V(ERROR).Info("Could not create ManagedCluster resource: " + target)
-			return ctrl.Result{}, err
+			return nil, ctrl.Result{}, err
 		}
 
 	} else if err != nil {
 
 		log.V(WARN).Info("Error when attempting to retreive the ManagedCluster resource: " + target)
-		return ctrl.Result{}, err
+		return nil, ctrl.Result{}, err
+	}
+
+	return &mc, ctrl.Result{}, nil
+}
+
+// setClaimOwnership stamps the claim name, namespace, pool and UID on the ManagedCluster. The names are
+// labels so they can be selected on, a name that is not a valid label value is only kept in the annotations.
+func setClaimOwnership(mc *mcv1.ManagedCluster, labels map[string]string, cc *hivev1.ClusterClaim) {
+	owner := map[string]string{
+		CLAIM_NAME_LABEL:      cc.Name,
+		CLAIM_NAMESPACE_LABEL: cc.Namespace,
+		CLUSTERPOOL_LABEL:     cc.Spec.ClusterPoolName,
+	}
+
+	if mc.Annotations == nil {
+		mc.Annotations = map[string]string{}
 	}
+	for key, val := range owner {
+		mc.Annotations[key] = val
+		if len(validation.IsValidLabelValue(val)) == 0 {
+			labels[key] = val
+		}
+	}
+	mc.Annotations[CLAIM_UID_ANNOTATION] = string(cc.UID)
+
+	if requester, found := cc.Annotations[REQUESTER_ANNOTATION]; found {
+		mc.Annotations[REQUESTER_ANNOTATION] = requester
+	}
+}
 
-	return ctrl.Result{}, nil
+// needsManagedClusterRepair reports whether the ManagedCluster of a claim imported by this controller is gone while
+// the cluster is still provisioned
+func needsManagedClusterRepair(r *ClusterClaimsReconciler, cc *hivev1.ClusterClaim) (bool, error) {
+	var mc mcv1.ManagedCluster
+	err := r.Get(context.Background(), types.NamespacedName{Name: cc.Spec.Namespace}, &mc)
+	if err == nil || !k8serrors.IsNotFound(err) {
+		return false, client.IgnoreNotFound(err)
+	}
+
+	cd, err := getClaimClusterDeployment(r, cc)
+	if err != nil || cd == nil || cd.DeletionTimestamp != nil {
+		// The cluster is being deprovisioned
+		return false, err
+	}
+	return true, nil
 }
 
+// migrateImportedMarker moves claims imported before the IMPORTED marker existed, which carry the finalizer and the
+// createmanagedcluster=false annotation written by the controller, to the IMPORTED marker
+func migrateImportedMarker(r *ClusterClaimsReconciler, cc *hivev1.ClusterClaim) error {
+	if _, found := cc.Annotations[IMPORTED]; found || !controllerutil.ContainsFinalizer(cc, FINALIZER) ||
+		strings.ToLower(cc.Annotations[CREATECM]) != "false" {
+		return nil
+	}
+
+	patch := client.MergeFrom(cc.DeepCopy())
+
+	cc.Annotations[IMPORTED] = "true"
+	delete(cc.Annotations, CREATECM)
+
+	r.Log.V(INFO).Info("Migrated the imported marker on cluster claim: " + cc.Name)
+	return r.Patch(context.Background(), cc, patch)
+}
 
 func removeFinalizer(r *ClusterClaimsReconciler, cc *hivev1.ClusterClaim) error {
 
@@ -183,73 +477,181 @@ func removeFinalizer(r *ClusterClaimsReconciler, cc *hivev1.ClusterClaim) error
 
 }
 
-func deleteResources(r *ClusterClaimsReconciler, target string) error {
+// deleteResources deletes the ManagedCluster of the claim, it returns true once the ManagedCluster is gone
+// or will not be deleted by this controller
+func deleteResources(r *ClusterClaimsReconciler, cc *hivev1.ClusterClaim) (bool, error) {
 	ctx := context.Background()
 	log := r.Log
+	target := cc.Spec.Namespace
 
 	var mc mcv1.ManagedCluster
 	if err := r.Get(ctx, types.NamespacedName{Name: target}, &mc); err != nil {
 
 		if k8serrors.IsNotFound(err) {
 			log.V(WARN).Info("The ManagedCluster resource: " + target + " was not found, can not delete")
-		} else {
-			return err
+			return true, nil
 		}
+		return false, err
+	}
 
-	} else {
+	if owned, reason := isClaimOwner(&mc, cc); !owned {
 
-		if mc.DeletionTimestamp == nil {
+		msg := "Skip deleting ManagedCluster " + target + ", it does not belong to the claim: " + reason
+		log.V(WARN).Info(msg)
+		recordEvent(r, cc, corev1.EventTypeWarning, "ManagedClusterNotOwned", msg)
+		return true, nil
+	}
+
+	policy := getDeletionPolicy(r, cc)
+	if policy != DeletionPolicyOrphan {
+		if err := deleteManagedClusterAddOns(r, cc); err != nil {
+			return false, err
+		}
+	}
 
-			err = r.Delete(ctx, &mc)
-			if err != nil {
-				log.V(WARN).Info("Error while deleting ManagedCluster resource: " + target)
+	switch policy {
+	case DeletionPolicyOrphan:
+		log.V(INFO).Info("Orphan ManagedCluster resource: " + target)
+		return true, nil
+
+	case DeletionPolicyDetach:
+		if mc.Spec.HubAcceptsClient && mc.DeletionTimestamp == nil {
+			patch := client.MergeFrom(mc.DeepCopy())
+			mc.Spec.HubAcceptsClient = false
+			if err := r.Patch(ctx, &mc, patch); err != nil {
+				log.V(WARN).Info("Error while detaching ManagedCluster resource: " + target)
+				return false, err
 			}
-			log.V(INFO).Info("Deleted ManagedCluster resource: " + target)
+		}
+		log.V(INFO).Info("Detached ManagedCluster resource: " + target)
+		return true, nil
+	}
 
-		} else {
-			log.V(WARN).Info("The managedCluster resource: " + target + " is already being deleted")
+	if mc.DeletionTimestamp == nil {
+
+		if err := r.Delete(ctx, &mc); err != nil {
+			if k8serrors.IsNotFound(err) {
+				return true, nil
+			}
+			log.V(WARN).Info("Error while deleting ManagedCluster resource: " + target)
+			return false, err
 		}
+		log.V(INFO).Info("Deleted ManagedCluster resource: " + target)
+
+	} else {
+		log.V(WARN).Info("The managedCluster resource: " + target + " is already being deleted")
 	}
 
-	return nil
+	// Wait for the klusterlet to be cleaned up and the ManagedCluster to be removed
+	return false, nil
 }
 
-func setRegion(r *ClusterClaimsReconciler, cc *hivev1.ClusterClaim) error {
+// getDeletionPolicy returns the claim's deletion policy annotation, or the controller default when not set or invalid
+func getDeletionPolicy(r *ClusterClaimsReconciler, cc *hivev1.ClusterClaim) string {
+	policy := r.DeletionPolicy
+	if policy == "" {
+		policy = DeletionPolicyDelete
+	}
 
-	clusterName := cc.Spec.Namespace
-	var cd hivev1.ClusterDeployment
-	log := r.Log
+	if value, found := cc.Annotations[DELETION_POLICY]; found {
+		if IsValidDeletionPolicy(value) {
+			return value
+		}
+		r.Log.V(WARN).Info("Invalid " + DELETION_POLICY + " annotation: \"" + value + "\" on cluster claim: " +
+			cc.Name + ", using " + policy)
+	}
+	return policy
+}
 
-	if err := r.Client.Get(
-		context.Background(),
-		types.NamespacedName{Namespace: clusterName,
-			Name: clusterName}, &cd); err != nil {
+// IsValidDeletionPolicy reports whether the value is one of Delete, Orphan or Detach
+func IsValidDeletionPolicy(policy string) bool {
+	switch policy {
+	case DeletionPolicyDelete, DeletionPolicyOrphan, DeletionPolicyDetach:
+		return true
+	}
+	return false
+}
 
-		if k8serrors.IsNotFound(err) {
-			r.Log.V(WARN).Info("No ClusterDeployment found for " + clusterName)
-		} else {
-			return err
+// isClaimOwner reports whether the ManagedCluster was created for the claim. The claim UID is the
+// strongest marker, older ManagedClusters fall back to the claim name or the provisioner annotation.
+// A claim restored from a hub backup has a new UID, it is matched on its name and namespace, the
+// ManagedCluster name already tells the cluster of a claim re-created with the same name apart.
+func isClaimOwner(mc *mcv1.ManagedCluster, cc *hivev1.ClusterClaim) (bool, string) {
+	if uid, found := mc.Annotations[CLAIM_UID_ANNOTATION]; found {
+		if uid != string(cc.UID) && (mc.Annotations[CLAIM_NAME_LABEL] != cc.Name ||
+			mc.Annotations[CLAIM_NAMESPACE_LABEL] != cc.Namespace) {
+
+			return false, "claim UID " + uid + " does not match"
 		}
+		return true, ""
+	}
 
+	if name, found := mc.Annotations[CLAIM_NAME_LABEL]; found {
+		if name != cc.Name || mc.Annotations[CLAIM_NAMESPACE_LABEL] != cc.Namespace {
+			return false, "created for claim " + mc.Annotations[CLAIM_NAMESPACE_LABEL] + "/" + name
+		}
+		return true, ""
+	}
+
+	if provisioner, found := mc.Annotations[PROVISIONER]; found {
+		// Written by the ManagedClusterReconciler as <name>.<namespace>.<kind>.<apiversion>
+		expected := fmt.Sprintf("%s.%s.%s.%s", cc.Name, cc.Namespace, "ClusterClaim", hivev1.SchemeGroupVersion.String())
+		if provisioner != expected {
+			return false, "provisioned by " + provisioner
+		}
+		return true, ""
+	}
+
+	return false, "no claim ownership marker found"
+}
+
+// recordEvent emits a Kubernetes event on the claim, when a recorder is configured
+func recordEvent(r *ClusterClaimsReconciler, cc *hivev1.ClusterClaim, eventType string, reason string, msg string) {
+	if r.Recorder != nil {
+		r.Recorder.Event(cc, eventType, reason, msg)
+	}
+}
+
+func setRegion(r *ClusterClaimsReconciler, cc *hivev1.ClusterClaim) error {
+
+	log := r.Log
+
+	platform, err := getClaimPlatform(r, cc)
+	if err != nil {
+		return err
 	}
 
 	if cc.Labels == nil {
 		cc.Labels = make(map[string]string)
 	}
 
-	switch {
-	case cd.Spec.Platform.AWS != nil:
-		cc.Labels["region"] = cd.Spec.Platform.AWS.Region
-	case cd.Spec.Platform.Azure != nil:
-		cc.Labels["region"] = cd.Spec.Platform.Azure.Region
-	case cd.Spec.Platform.GCP != nil:
-		cc.Labels["region"] = cd.Spec.Platform.GCP.Region
+	// A platform without a region gets no region label, rather than one left over on the claim
+	region, datacenter := getPlatformLocation(platform)
+	if region == "" {
+		log.V(DEBUG).Info("Platform: \"" + getPlatformName(platform) + "\" has no region")
 	}
-	log.V(DEBUG).Info("Detected region: \"" + cc.Labels["region"] + "\"")
+	setLocationLabel(r, cc, REGION_LABEL, region)
+	setLocationLabel(r, cc, DATACENTER_LABEL, datacenter)
+	log.V(DEBUG).Info("Detected region: \"" + cc.Labels[REGION_LABEL] + "\"")
 
 	return nil
 }
 
+// setLocationLabel sets the location label on the claim, it is removed when the platform has no such location
+// or its name, which is free-form on some platforms, is not a valid label value
+func setLocationLabel(r *ClusterClaimsReconciler, cc *hivev1.ClusterClaim, key string, value string) {
+	if value == "" {
+		delete(cc.Labels, key)
+		return
+	}
+	if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+		r.Log.V(WARN).Info("Skip label: " + key + ", \"" + value + "\" is not a valid label value")
+		delete(cc.Labels, key)
+		return
+	}
+	cc.Labels[key] = value
+}
+
 func setClusterSetLabel(r *ClusterClaimsReconciler, cc *hivev1.ClusterClaim) error {
 	var cp hivev1.ClusterPool
 	if err := r.Client.Get(
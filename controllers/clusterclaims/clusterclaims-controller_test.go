@@ -2,6 +2,7 @@ package clusterlcaims
 
 import (
 	"context"
+	"strings"
 	"testing"
 	"time"
 
@@ -12,10 +13,14 @@ import (
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/zap/zapcore"
 	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	addonv1alpha1 "open-cluster-management.io/api/addon/v1alpha1"
 	mcv1 "open-cluster-management.io/api/cluster/v1"
+	mcv1beta2 "open-cluster-management.io/api/cluster/v1beta2"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	clientfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
@@ -34,6 +39,8 @@ func init() {
 	corev1.SchemeBuilder.AddToScheme(s)
 	hivev1.SchemeBuilder.AddToScheme(s)
 	mcv1.AddToScheme(s)
+	addonv1alpha1.AddToScheme(s)
+	mcv1beta2.AddToScheme(s)
 }
 
 func getRequest() ctrl.Request {
@@ -107,9 +114,10 @@ func GetClusterClaimsReconciler() *ClusterClaimsReconciler {
 	// Log levels: DebugLevel  DebugLevel
 	ctrl.SetLogger(zap.New(zap.UseDevMode(true), zap.Level(zapcore.DebugLevel)))
 	return &ClusterClaimsReconciler{
-		Client: clientfake.NewClientBuilder().WithScheme(s).Build(),
-		Log:    ctrl.Log.WithName("controllers").WithName("ClusterClaimsReconciler"),
-		Scheme: s,
+		Client:   clientfake.NewClientBuilder().WithScheme(s).WithStatusSubresource(&hivev1.ClusterClaim{}).Build(),
+		Log:      ctrl.Log.WithName("controllers").WithName("ClusterClaimsReconciler"),
+		Scheme:   s,
+		Recorder: record.NewFakeRecorder(10),
 	}
 }
 
@@ -177,13 +185,17 @@ func TestReconcileDeletedClusterClaim(t *testing.T) {
 
 	cc.DeletionTimestamp = &v1.Time{Time: time.Now()}
 
-	mc := &mcv1.ManagedCluster{ObjectMeta: v1.ObjectMeta{Name: CLUSTER01}}
+	mc := &mcv1.ManagedCluster{ObjectMeta: v1.ObjectMeta{
+		Name:        CLUSTER01,
+		Annotations: map[string]string{CLAIM_UID_ANNOTATION: string(cc.UID)},
+	}}
 
 	ccr.Client.Create(ctx, mc, &client.CreateOptions{})
 
-	err := deleteResources(ccr, CLUSTER01)
+	deleted, err := deleteResources(ccr, cc)
 
 	assert.Nil(t, err, "nil, when clusterClaim is found reconcile was successful")
+	assert.False(t, deleted, "false, until the ManagedCluster is confirmed gone")
 
 	err = ccr.Client.Get(ctx, getNamespaceName("", CLUSTER01), mc)
 	assert.NotNil(t, err, "nil, when managedCluster resource is retrieved")
@@ -399,7 +411,8 @@ func TestReconcileClusterClaimsNoReimport(t *testing.T) {
 	var cc hivev1.ClusterClaim
 	err = ccr.Client.Get(ctx, getNamespaceName(CC_NAMESPACE, CC_NAME), &cc)
 	assert.Nil(t, err, "nil, when clusterClaim is retrieved")
-	assert.Equal(t, "false", cc.Annotations[CREATECM], "createmanagedcluster annotation should be false")
+	assert.Equal(t, "true", cc.Annotations[IMPORTED], "imported annotation should be true")
+	assert.NotContains(t, cc.Annotations, CREATECM, "createmanagedcluster annotation is left to the user")
 
 	// Reconcile again after detach - ManagedCluster should NOT be recreated
 	_, err = ccr.Reconcile(ctx, getRequest())
@@ -424,8 +437,9 @@ func TestReconcileClusterClaimsNotFound(t *testing.T) {
 	cl.Spec.Namespace = ""
 	ccr.Client.Create(ctx, cl, &client.CreateOptions{})
 
-	_, err = ccr.Reconcile(ctx, getRequest())
+	res, err := ccr.Reconcile(ctx, getRequest())
 	assert.Nil(t, err, "nil, wait clusterclaim complete")
+	assert.Equal(t, WAITING_REQUEUE, res.RequeueAfter, "claim waiting for a cluster should be requeued")
 }
 
 func TestReconcileClusterClaimsDeleting(t *testing.T) {
@@ -443,3 +457,482 @@ func TestReconcileClusterClaimsDeleting(t *testing.T) {
 	_, err := ccr.Reconcile(ctx, getRequest())
 	assert.Nil(t, err, "nil, when clusterclaim is deleting")
 }
+
+func TestClaimRequestsForManagedCluster(t *testing.T) {
+
+	ctx := context.Background()
+
+	ccr := GetClusterClaimsReconciler()
+
+	cd := GetClusterDeployment(CLUSTER01, "aws")
+	cd.Spec.ClusterPoolRef = &hivev1.ClusterPoolReference{Namespace: CC_NAMESPACE, PoolName: CP_NAME, ClaimName: CC_NAME}
+	ccr.Client.Create(ctx, cd, &client.CreateOptions{})
+
+	mc := &mcv1.ManagedCluster{ObjectMeta: v1.ObjectMeta{Name: CLUSTER01}}
+
+	requests := claimRequestsForManagedCluster(ccr, mc)
+	assert.Equal(t, []ctrl.Request{getRequest()}, requests, "ManagedCluster should map to its cluster claim")
+
+	mc.Name = "unclaimed"
+	assert.Empty(t, claimRequestsForManagedCluster(ccr, mc), "no requests when there is no ClusterDeployment")
+}
+
+func TestClaimRequestsForClusterDeployment(t *testing.T) {
+
+	cd := GetClusterDeployment(CLUSTER01, "aws")
+	assert.Empty(t, claimRequestsForClusterDeployment(cd), "no requests when the ClusterDeployment is not in a pool")
+
+	cd.Spec.ClusterPoolRef = &hivev1.ClusterPoolReference{Namespace: CC_NAMESPACE, PoolName: CP_NAME}
+	assert.Empty(t, claimRequestsForClusterDeployment(cd), "no requests when the ClusterDeployment is not claimed")
+
+	cd.Spec.ClusterPoolRef.ClaimName = CC_NAME
+	assert.Equal(t, []ctrl.Request{getRequest()}, claimRequestsForClusterDeployment(cd),
+		"ClusterDeployment should map to its cluster claim")
+}
+
+func TestReconcileClusterClaimsOwnership(t *testing.T) {
+
+	ctx := context.Background()
+
+	ccr := GetClusterClaimsReconciler()
+
+	cc := GetClusterClaim(CC_NAMESPACE, CC_NAME, CLUSTER01)
+	cc.UID = "6e8a5e4f-1d4b-4e57-9b6f-0f2a3c4d5e6f"
+	ccr.Client.Create(ctx, cc, &client.CreateOptions{})
+
+	_, err := ccr.Reconcile(ctx, getRequest())
+	assert.Nil(t, err, "nil, when clusterClaim is found reconcile was successful")
+
+	var mc mcv1.ManagedCluster
+	err = ccr.Client.Get(ctx, getNamespaceName("", CLUSTER01), &mc)
+	assert.Nil(t, err, "nil, when managedCluster resource is retrieved")
+
+	assert.Equal(t, CC_NAME, mc.Labels[CLAIM_NAME_LABEL], "label claim-name should equal the claim name")
+	assert.Equal(t, CC_NAMESPACE, mc.Labels[CLAIM_NAMESPACE_LABEL], "label claim-namespace should equal the claim namespace")
+	assert.Equal(t, cc.Spec.ClusterPoolName, mc.Labels[CLUSTERPOOL_LABEL], "label clusterpool should equal the pool name")
+	assert.Equal(t, string(cc.UID), mc.Annotations[CLAIM_UID_ANNOTATION], "annotation claim-uid should equal the claim UID")
+}
+
+func TestSetClaimOwnershipLongName(t *testing.T) {
+
+	cc := GetClusterClaim(CC_NAMESPACE, strings.Repeat("a", 70), CLUSTER01)
+	mc := &mcv1.ManagedCluster{}
+	labels := map[string]string{}
+
+	setClaimOwnership(mc, labels, cc)
+
+	assert.NotContains(t, labels, CLAIM_NAME_LABEL, "a name longer than a label value is not set as label")
+	assert.Equal(t, cc.Name, mc.Annotations[CLAIM_NAME_LABEL], "the name is always kept in the annotations")
+	assert.Equal(t, CC_NAMESPACE, labels[CLAIM_NAMESPACE_LABEL], "label claim-namespace should equal the claim namespace")
+}
+
+func TestReconcileDeletedClusterClaimNotOwner(t *testing.T) {
+
+	ctx := context.Background()
+
+	ccr := GetClusterClaimsReconciler()
+
+	cc := GetClusterClaim(CC_NAMESPACE, CC_NAME, CLUSTER01)
+	cc.UID = "6e8a5e4f-1d4b-4e57-9b6f-0f2a3c4d5e6f"
+
+	// Imported by hand, no ownership marker
+	mc := &mcv1.ManagedCluster{ObjectMeta: v1.ObjectMeta{Name: CLUSTER01}}
+	ccr.Client.Create(ctx, mc, &client.CreateOptions{})
+
+	deleted, err := deleteResources(ccr, cc)
+	assert.Nil(t, err, "nil, when the ManagedCluster deletion is skipped")
+	assert.True(t, deleted, "true, when the ManagedCluster is not owned by the claim")
+
+	err = ccr.Client.Get(ctx, getNamespaceName("", CLUSTER01), mc)
+	assert.Nil(t, err, "nil, when the ManagedCluster without ownership marker is kept")
+
+	recorder := ccr.Recorder.(*record.FakeRecorder)
+	assert.Len(t, recorder.Events, 1, "an event should be recorded when deletion is skipped")
+	assert.Contains(t, <-recorder.Events, "ManagedClusterNotOwned", "event reason should be ManagedClusterNotOwned")
+
+	// Created for a previous claim with the same name
+	mc.Annotations = map[string]string{CLAIM_UID_ANNOTATION: "0b5b2a9d-7c1e-4d1a-8f3e-2d4c6b8a0e1f"}
+	err = ccr.Client.Update(ctx, mc)
+	assert.Nil(t, err, "nil, when the ManagedCluster is updated")
+
+	_, err = deleteResources(ccr, cc)
+	assert.Nil(t, err, "nil, when the ManagedCluster deletion is skipped")
+
+	err = ccr.Client.Get(ctx, getNamespaceName("", CLUSTER01), mc)
+	assert.Nil(t, err, "nil, when the ManagedCluster of another claim is kept")
+}
+
+func TestReconcileDeletedRestoredClusterClaim(t *testing.T) {
+
+	ctx := context.Background()
+
+	ccr := GetClusterClaimsReconciler()
+
+	// The claim restored from a hub backup has a new UID, the ManagedCluster keeps the one of the original claim
+	cc := GetClusterClaim(CC_NAMESPACE, CC_NAME, CLUSTER01)
+	cc.UID = "6e8a5e4f-1d4b-4e57-9b6f-0f2a3c4d5e6f"
+
+	mc := &mcv1.ManagedCluster{ObjectMeta: v1.ObjectMeta{
+		Name: CLUSTER01,
+		Annotations: map[string]string{
+			CLAIM_UID_ANNOTATION:  "0b5b2a9d-7c1e-4d1a-8f3e-2d4c6b8a0e1f",
+			CLAIM_NAME_LABEL:      CC_NAME,
+			CLAIM_NAMESPACE_LABEL: CC_NAMESPACE,
+		},
+	}}
+	ccr.Client.Create(ctx, mc, &client.CreateOptions{})
+
+	_, err := deleteResources(ccr, cc)
+	assert.Nil(t, err, "nil, when the ManagedCluster is deleted")
+
+	err = ccr.Client.Get(ctx, getNamespaceName("", CLUSTER01), mc)
+	assert.True(t, k8serrors.IsNotFound(err), "the ManagedCluster of the restored claim should be deleted")
+}
+
+func TestIsClaimOwner(t *testing.T) {
+
+	cc := GetClusterClaim(CC_NAMESPACE, CC_NAME, CLUSTER01)
+	cc.UID = "6e8a5e4f-1d4b-4e57-9b6f-0f2a3c4d5e6f"
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		owned       bool
+	}{
+		{"no marker", nil, false},
+		{"matching uid", map[string]string{CLAIM_UID_ANNOTATION: string(cc.UID)}, true},
+		{"other uid", map[string]string{CLAIM_UID_ANNOTATION: "other"}, false},
+		{"restored claim", map[string]string{
+			CLAIM_UID_ANNOTATION: "other", CLAIM_NAME_LABEL: CC_NAME, CLAIM_NAMESPACE_LABEL: CC_NAMESPACE}, true},
+		{"other uid and claim name", map[string]string{
+			CLAIM_UID_ANNOTATION: "other", CLAIM_NAME_LABEL: "other", CLAIM_NAMESPACE_LABEL: CC_NAMESPACE}, false},
+		{"matching claim name", map[string]string{CLAIM_NAME_LABEL: CC_NAME, CLAIM_NAMESPACE_LABEL: CC_NAMESPACE}, true},
+		{"other claim namespace", map[string]string{CLAIM_NAME_LABEL: CC_NAME, CLAIM_NAMESPACE_LABEL: "other"}, false},
+		{"matching provisioner", map[string]string{PROVISIONER: CC_NAME + "." + CC_NAMESPACE + ".ClusterClaim.hive.openshift.io/v1"}, true},
+		{"other provisioner", map[string]string{PROVISIONER: "other." + CC_NAMESPACE + ".ClusterClaim.hive.openshift.io/v1"}, false},
+	}
+
+	for _, tc := range tests {
+		mc := &mcv1.ManagedCluster{ObjectMeta: v1.ObjectMeta{Name: CLUSTER01, Annotations: tc.annotations}}
+		owned, _ := isClaimOwner(mc, cc)
+		assert.Equal(t, tc.owned, owned, tc.name)
+	}
+}
+
+func TestReconcileDeletedClusterClaimWaitsForManagedCluster(t *testing.T) {
+
+	ctx := context.Background()
+
+	ccr := GetClusterClaimsReconciler()
+
+	cc := GetClusterClaim(CC_NAMESPACE, CC_NAME, CLUSTER01)
+	cc.Finalizers = []string{FINALIZER}
+	ccr.Client.Create(ctx, cc, &client.CreateOptions{})
+
+	// The registration controller holds the ManagedCluster until the klusterlet is cleaned up
+	mc := &mcv1.ManagedCluster{ObjectMeta: v1.ObjectMeta{
+		Name:        CLUSTER01,
+		Annotations: map[string]string{CLAIM_UID_ANNOTATION: string(cc.UID)},
+		Finalizers:  []string{"cluster.open-cluster-management.io/api-resource-cleanup"},
+	}}
+	ccr.Client.Create(ctx, mc, &client.CreateOptions{})
+
+	ccr.Client.Delete(ctx, cc)
+
+	res, err := ccr.Reconcile(ctx, getRequest())
+	assert.Nil(t, err, "nil, when the ManagedCluster delete was issued")
+	assert.Equal(t, DELETE_REQUEUE, res.RequeueAfter, "claim should be requeued while the ManagedCluster is deleting")
+
+	err = ccr.Client.Get(ctx, getNamespaceName(CC_NAMESPACE, CC_NAME), cc)
+	assert.Nil(t, err, "nil, the claim finalizer is held while the ManagedCluster exists")
+	assert.Contains(t, cc.Finalizers, FINALIZER, "claim finalizer should be held")
+
+	// The klusterlet is gone
+	err = ccr.Client.Get(ctx, getNamespaceName("", CLUSTER01), mc)
+	assert.Nil(t, err, "nil, when managedCluster resource is retrieved")
+	mc.Finalizers = nil
+	err = ccr.Client.Update(ctx, mc)
+	assert.Nil(t, err, "nil, when managedCluster finalizer is removed")
+
+	res, err = ccr.Reconcile(ctx, getRequest())
+	assert.Nil(t, err, "nil, when the ManagedCluster is gone")
+	assert.Zero(t, res.RequeueAfter, "no requeue once the ManagedCluster is gone")
+
+	err = ccr.Client.Get(ctx, getNamespaceName(CC_NAMESPACE, CC_NAME), cc)
+	assert.NotNil(t, err, "not nil, the claim is removed with the finalizer")
+}
+
+func TestReconcileDeletedClusterClaimTimeout(t *testing.T) {
+
+	ctx := context.Background()
+
+	ccr := GetClusterClaimsReconciler()
+	ccr.DeleteTimeout = time.Nanosecond
+
+	cc := GetClusterClaim(CC_NAMESPACE, CC_NAME, CLUSTER01)
+	cc.Finalizers = []string{FINALIZER}
+	ccr.Client.Create(ctx, cc, &client.CreateOptions{})
+
+	mc := &mcv1.ManagedCluster{ObjectMeta: v1.ObjectMeta{
+		Name:        CLUSTER01,
+		Annotations: map[string]string{CLAIM_UID_ANNOTATION: string(cc.UID)},
+		Finalizers:  []string{"cluster.open-cluster-management.io/api-resource-cleanup"},
+	}}
+	ccr.Client.Create(ctx, mc, &client.CreateOptions{})
+
+	ccr.Client.Delete(ctx, cc)
+	time.Sleep(time.Millisecond)
+
+	res, err := ccr.Reconcile(ctx, getRequest())
+	assert.Nil(t, err, "nil, when the wait for the ManagedCluster timed out")
+	assert.Zero(t, res.RequeueAfter, "no requeue after the timeout")
+
+	err = ccr.Client.Get(ctx, getNamespaceName(CC_NAMESPACE, CC_NAME), cc)
+	assert.NotNil(t, err, "not nil, the claim finalizer is released after the timeout")
+
+	recorder := ccr.Recorder.(*record.FakeRecorder)
+	assert.Contains(t, <-recorder.Events, "ManagedClusterDeleteTimeout", "event reason should be ManagedClusterDeleteTimeout")
+}
+
+func TestReconcileDeletedClusterClaimDeletionPolicy(t *testing.T) {
+
+	tests := []struct {
+		annotation       string
+		defaultPolicy    string
+		expectMC         bool
+		expectHubAccepts bool
+	}{
+		{annotation: "", defaultPolicy: "", expectMC: false},
+		{annotation: DeletionPolicyOrphan, defaultPolicy: "", expectMC: true, expectHubAccepts: true},
+		{annotation: DeletionPolicyDetach, defaultPolicy: "", expectMC: true, expectHubAccepts: false},
+		{annotation: "", defaultPolicy: DeletionPolicyDetach, expectMC: true, expectHubAccepts: false},
+		{annotation: DeletionPolicyDelete, defaultPolicy: DeletionPolicyOrphan, expectMC: false},
+		{annotation: "Invalid", defaultPolicy: DeletionPolicyOrphan, expectMC: true, expectHubAccepts: true},
+	}
+
+	for _, tc := range tests {
+		ctx := context.Background()
+
+		ccr := GetClusterClaimsReconciler()
+		ccr.DeletionPolicy = tc.defaultPolicy
+
+		cc := GetClusterClaim(CC_NAMESPACE, CC_NAME, CLUSTER01)
+		cc.Finalizers = []string{FINALIZER}
+		if tc.annotation != "" {
+			cc.Annotations = map[string]string{DELETION_POLICY: tc.annotation}
+		}
+		ccr.Client.Create(ctx, cc, &client.CreateOptions{})
+
+		mc := &mcv1.ManagedCluster{
+			ObjectMeta: v1.ObjectMeta{
+				Name:        CLUSTER01,
+				Annotations: map[string]string{CLAIM_UID_ANNOTATION: string(cc.UID)},
+			},
+			Spec: mcv1.ManagedClusterSpec{HubAcceptsClient: true},
+		}
+		ccr.Client.Create(ctx, mc, &client.CreateOptions{})
+
+		ccr.Client.Delete(ctx, cc)
+
+		_, err := ccr.Reconcile(ctx, getRequest())
+		assert.Nil(t, err, "nil, when the claim deletion is reconciled")
+
+		name := "annotation: " + tc.annotation + " default: " + tc.defaultPolicy
+		err = ccr.Client.Get(ctx, getNamespaceName("", CLUSTER01), mc)
+		if !tc.expectMC {
+			assert.NotNil(t, err, name+", the ManagedCluster should be deleted")
+			continue
+		}
+		assert.Nil(t, err, name+", the ManagedCluster should be kept")
+		assert.Equal(t, tc.expectHubAccepts, mc.Spec.HubAcceptsClient, name+", hubAcceptsClient")
+
+		err = ccr.Client.Get(ctx, getNamespaceName(CC_NAMESPACE, CC_NAME), cc)
+		assert.NotNil(t, err, name+", the claim finalizer should be released")
+	}
+}
+
+func TestReconcileClusterClaimsRepairManagedCluster(t *testing.T) {
+
+	ctx := context.Background()
+
+	ccr := GetClusterClaimsReconciler()
+	ccr.RepairManagedCluster = true
+	recorder := ccr.Recorder.(*record.FakeRecorder)
+
+	ccr.Client.Create(ctx, GetClusterClaim(CC_NAMESPACE, CC_NAME, CLUSTER01), &client.CreateOptions{})
+	ccr.Client.Create(ctx, GetClusterDeployment(CLUSTER01, "aws"), &client.CreateOptions{})
+
+	_, err := ccr.Reconcile(ctx, getRequest())
+	assert.Nil(t, err, "nil, when clusterClaim is found reconcile was successful")
+
+	// The ManagedCluster is removed out-of-band
+	var mc mcv1.ManagedCluster
+	err = ccr.Client.Get(ctx, getNamespaceName("", CLUSTER01), &mc)
+	assert.Nil(t, err, "nil, when managedCluster resource is retrieved")
+	err = ccr.Client.Delete(ctx, &mc)
+	assert.Nil(t, err, "nil, when managedCluster resource is deleted")
+
+	_, err = ccr.Reconcile(ctx, getRequest())
+	assert.Nil(t, err, "nil, when clusterClaim is found reconcile was successful")
+
+	err = ccr.Client.Get(ctx, getNamespaceName("", CLUSTER01), &mc)
+	assert.Nil(t, err, "nil, the managedCluster is re-created")
+	assert.Equal(t, "Amazon", mc.Labels[CLOUD_LABEL], "the re-created managedCluster is labeled")
+
+	select {
+	case e := <-recorder.Events:
+		assert.Contains(t, e, "ManagedClusterRecreated", "the re-creation is explained")
+	default:
+		t.Error("expected a ManagedClusterRecreated event")
+	}
+}
+
+func TestReconcileClusterClaimsNoRepairForOptOut(t *testing.T) {
+
+	ctx := context.Background()
+
+	ccr := GetClusterClaimsReconciler()
+	ccr.RepairManagedCluster = true
+
+	// The claim author opted out of the import
+	cc := GetClusterClaim(CC_NAMESPACE, CC_NAME, CLUSTER01)
+	cc.Annotations = map[string]string{CREATECM: "false"}
+	ccr.Client.Create(ctx, cc, &client.CreateOptions{})
+	ccr.Client.Create(ctx, GetClusterDeployment(CLUSTER01, "aws"), &client.CreateOptions{})
+
+	_, err := ccr.Reconcile(ctx, getRequest())
+	assert.Nil(t, err, "nil, when clusterClaim is found reconcile was successful")
+
+	var mc mcv1.ManagedCluster
+	err = ccr.Client.Get(ctx, getNamespaceName("", CLUSTER01), &mc)
+	assert.True(t, k8serrors.IsNotFound(err), "no managedCluster for a claim that opted out")
+}
+
+func TestReconcileClusterClaimsNoRepairWhenDisabled(t *testing.T) {
+
+	ctx := context.Background()
+
+	ccr := GetClusterClaimsReconciler()
+
+	ccr.Client.Create(ctx, GetClusterClaim(CC_NAMESPACE, CC_NAME, CLUSTER01), &client.CreateOptions{})
+	ccr.Client.Create(ctx, GetClusterDeployment(CLUSTER01, "aws"), &client.CreateOptions{})
+
+	_, err := ccr.Reconcile(ctx, getRequest())
+	assert.Nil(t, err, "nil, when clusterClaim is found reconcile was successful")
+
+	var mc mcv1.ManagedCluster
+	err = ccr.Client.Get(ctx, getNamespaceName("", CLUSTER01), &mc)
+	assert.Nil(t, err, "nil, when managedCluster resource is retrieved")
+	err = ccr.Client.Delete(ctx, &mc)
+	assert.Nil(t, err, "nil, when managedCluster resource is deleted")
+
+	_, err = ccr.Reconcile(ctx, getRequest())
+	assert.Nil(t, err, "nil, when clusterClaim is found reconcile was successful")
+
+	err = ccr.Client.Get(ctx, getNamespaceName("", CLUSTER01), &mc)
+	assert.True(t, k8serrors.IsNotFound(err), "the managedCluster is not repaired by default")
+}
+
+func TestReconcileClusterClaimsMigrateImportedMarker(t *testing.T) {
+
+	ctx := context.Background()
+
+	ccr := GetClusterClaimsReconciler()
+	ccr.RepairManagedCluster = true
+
+	// Imported before the imported marker
+	cc := GetClusterClaim(CC_NAMESPACE, CC_NAME, CLUSTER01)
+	cc.Annotations = map[string]string{CREATECM: "false"}
+	cc.Finalizers = []string{FINALIZER}
+	ccr.Client.Create(ctx, cc, &client.CreateOptions{})
+	ccr.Client.Create(ctx, GetClusterDeployment(CLUSTER01, "aws"), &client.CreateOptions{})
+
+	_, err := ccr.Reconcile(ctx, getRequest())
+	assert.Nil(t, err, "nil, when clusterClaim is found reconcile was successful")
+
+	err = ccr.Client.Get(ctx, getNamespaceName(CC_NAMESPACE, CC_NAME), cc)
+	assert.Nil(t, err, "nil, when clusterClaim is retrieved")
+	assert.Equal(t, "true", cc.Annotations[IMPORTED], "imported annotation is migrated")
+	assert.NotContains(t, cc.Annotations, CREATECM, "createmanagedcluster annotation written by the controller is removed")
+
+	// Once migrated the claim is treated as imported, so its missing ManagedCluster is repaired
+	var mc mcv1.ManagedCluster
+	err = ccr.Client.Get(ctx, getNamespaceName("", CLUSTER01), &mc)
+	assert.Nil(t, err, "nil, when managedCluster resource is retrieved")
+}
+
+func TestReconcileClusterClaimsOptOutAfterImport(t *testing.T) {
+
+	ctx := context.Background()
+
+	ccr := GetClusterClaimsReconciler()
+	ccr.RepairManagedCluster = true
+
+	ccr.Client.Create(ctx, GetClusterClaim(CC_NAMESPACE, CC_NAME, CLUSTER01), &client.CreateOptions{})
+	ccr.Client.Create(ctx, GetClusterDeployment(CLUSTER01, "aws"), &client.CreateOptions{})
+
+	_, err := ccr.Reconcile(ctx, getRequest())
+	assert.Nil(t, err, "nil, when clusterClaim is found reconcile was successful")
+
+	// The user opts out and removes the ManagedCluster
+	var cc hivev1.ClusterClaim
+	err = ccr.Client.Get(ctx, getNamespaceName(CC_NAMESPACE, CC_NAME), &cc)
+	assert.Nil(t, err, "nil, when clusterClaim is retrieved")
+	cc.Annotations[CREATECM] = "false"
+	err = ccr.Client.Update(ctx, &cc)
+	assert.Nil(t, err, "nil, when clusterClaim is updated")
+
+	var mc mcv1.ManagedCluster
+	err = ccr.Client.Get(ctx, getNamespaceName("", CLUSTER01), &mc)
+	assert.Nil(t, err, "nil, when managedCluster resource is retrieved")
+	err = ccr.Client.Delete(ctx, &mc)
+	assert.Nil(t, err, "nil, when managedCluster resource is deleted")
+
+	_, err = ccr.Reconcile(ctx, getRequest())
+	assert.Nil(t, err, "nil, when clusterClaim is found reconcile was successful")
+
+	err = ccr.Client.Get(ctx, getNamespaceName("", CLUSTER01), &mc)
+	assert.True(t, k8serrors.IsNotFound(err), "the user intent wins over the repair")
+
+	err = ccr.Client.Get(ctx, getNamespaceName(CC_NAMESPACE, CC_NAME), &cc)
+	assert.Nil(t, err, "nil, when clusterClaim is retrieved")
+	assert.Equal(t, "false", cc.Annotations[CREATECM], "the user annotation is kept")
+}
+
+func TestReconcileClusterClaimsOptOutStopsSync(t *testing.T) {
+
+	ctx := context.Background()
+
+	ccr := GetClusterClaimsReconciler()
+	ccr.SyncLabels = true
+	ccr.PropagateClusterSet = true
+
+	cc := GetClusterClaim(CC_NAMESPACE, CC_NAME, CLUSTER01)
+	cp := GetClusterPool(CC_NAMESPACE, cc.Spec.ClusterPoolName, map[string]string{ClusterSetLabel: "old-set"})
+	ccr.Client.Create(ctx, cp, &client.CreateOptions{})
+	ccr.Client.Create(ctx, cc, &client.CreateOptions{})
+
+	_, err := ccr.Reconcile(ctx, getRequest())
+	assert.Nil(t, err, "nil, when clusterClaim is found reconcile was successful")
+
+	// The user opts out, then changes a label and the pool moves to another set
+	err = ccr.Client.Get(ctx, getNamespaceName(CC_NAMESPACE, CC_NAME), cc)
+	assert.Nil(t, err, "nil, when clusterClaim is retrieved")
+	cc.Annotations[CREATECM] = "false"
+	cc.Labels["usage"] = "testing"
+	err = ccr.Client.Update(ctx, cc)
+	assert.Nil(t, err, "nil, when clusterClaim is updated")
+
+	cp.Labels[ClusterSetLabel] = "new-set"
+	err = ccr.Client.Update(ctx, cp)
+	assert.Nil(t, err, "nil, when clusterPool is updated")
+
+	_, err = ccr.Reconcile(ctx, getRequest())
+	assert.Nil(t, err, "nil, when clusterClaim is found reconcile was successful")
+
+	var mc mcv1.ManagedCluster
+	err = ccr.Client.Get(ctx, getNamespaceName("", CLUSTER01), &mc)
+	assert.Nil(t, err, "nil, when managedCluster resource is retrieved")
+	assert.Equal(t, "production", mc.Labels["usage"], "the labels are not synced after the opt-out")
+	assert.Equal(t, "old-set", mc.Labels[ClusterSetLabel], "the cluster is not moved after the opt-out")
+}
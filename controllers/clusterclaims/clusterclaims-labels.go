@@ -0,0 +1,384 @@
+// Copyright Contributors to the Open Cluster Management project.
+
+package clusterlcaims
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	hivev1 "github.com/openshift/hive/apis/hive/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	mcv1 "open-cluster-management.io/api/cluster/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// MANAGED_LABELS lists, comma separated, the ManagedCluster label keys that were copied from the claim
+const MANAGED_LABELS = "clusterclaims-controller.open-cluster-management.io/managed-labels"
+
+// DEFAULT_LABELS lists, comma separated on a ClusterPool, key=value labels added to every claim from the pool
+// that does not set the label itself
+const DEFAULT_LABELS = "cluster.open-cluster-management.io/default-labels"
+
+// POOL_DEFAULT_LABELS lists, comma separated, the key=value labels saved on the claim from the pool default labels
+const POOL_DEFAULT_LABELS = "clusterclaims-controller.open-cluster-management.io/pool-default-labels"
+
+// getManagedLabelKeys returns the label keys recorded as owned by the controller, and whether they were recorded
+func getManagedLabelKeys(mc *mcv1.ManagedCluster) (map[string]bool, bool) {
+	value, found := mc.Annotations[MANAGED_LABELS]
+	if !found {
+		return nil, false
+	}
+
+	keys := map[string]bool{}
+	for _, key := range strings.Split(value, ",") {
+		if key != "" {
+			keys[key] = true
+		}
+	}
+	return keys, true
+}
+
+// setManagedLabelKeys records the label keys owned by the controller on the ManagedCluster
+func setManagedLabelKeys(mc *mcv1.ManagedCluster, keys map[string]bool) {
+	sorted := make([]string, 0, len(keys))
+	for key := range keys {
+		sorted = append(sorted, key)
+	}
+	sort.Strings(sorted)
+
+	if mc.Annotations == nil {
+		mc.Annotations = map[string]string{}
+	}
+	mc.Annotations[MANAGED_LABELS] = strings.Join(sorted, ",")
+}
+
+// syncManagedClusterLabels keeps the claim labels on an imported ManagedCluster in step with the claim.
+// Only labels recorded in the managed-labels annotation are updated or removed, labels set by others are left alone.
+// The clusterset label is never synced, the ManagedCluster only changes set with syncManagedClusterSet.
+func syncManagedClusterLabels(r *ClusterClaimsReconciler, cc *hivev1.ClusterClaim) error {
+	ctx := context.Background()
+	log := r.Log
+
+	var mc mcv1.ManagedCluster
+	if err := r.Get(ctx, types.NamespacedName{Name: cc.Spec.Namespace}, &mc); err != nil {
+		if k8serrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	if mc.DeletionTimestamp != nil {
+		return nil
+	}
+
+	owned, found := getManagedLabelKeys(&mc)
+	if !found {
+		// Not created by this controller, or created before labels were tracked
+		log.V(DEBUG).Info("ManagedCluster: " + mc.Name + " has no managed labels, skip label sync")
+		return nil
+	}
+
+	labels := map[string]string{}
+	for key, val := range filterLabels(r, cc.Labels) {
+		if key != ClusterSetLabel {
+			labels[key] = val
+		}
+	}
+	delete(owned, ClusterSetLabel)
+
+	original := mc.DeepCopy()
+	if mc.Labels == nil {
+		mc.Labels = map[string]string{}
+	}
+
+	newOwned := map[string]bool{}
+	for key, val := range labels {
+		if current, exists := mc.Labels[key]; exists && !owned[key] {
+			if current != val {
+				log.V(DEBUG).Info("Label: " + key + " is not managed by the claim, skip")
+			}
+			continue
+		}
+		mc.Labels[key] = val
+		newOwned[key] = true
+	}
+
+	for key := range owned {
+		if _, found := labels[key]; !found {
+			log.V(DEBUG).Info("Remove label: " + key)
+			delete(mc.Labels, key)
+		}
+	}
+
+	setManagedLabelKeys(&mc, newOwned)
+
+	if equality.Semantic.DeepEqual(original.Labels, mc.Labels) &&
+		equality.Semantic.DeepEqual(original.Annotations, mc.Annotations) {
+		return nil
+	}
+
+	log.V(DEBUG).Info("Sync labels from cluster claim: " + cc.Name + " to ManagedCluster: " + mc.Name)
+	return r.Patch(ctx, &mc, client.MergeFrom(original))
+}
+
+// LabelRules select the claim labels that are propagated to the ManagedCluster, and the keys they are propagated as.
+// Patterns match label keys, where * matches any sequence of characters, including the / of a prefix.
+type LabelRules struct {
+	// Allow lists the key patterns that are propagated, all keys when empty
+	Allow []string
+
+	// Deny lists the key patterns that are never propagated, it wins over Allow
+	Deny []string
+
+	// Rewrite renames label keys, the original key is matched against Allow and Deny
+	Rewrite map[string]string
+}
+
+// ParseLabelRules builds the label rules from comma separated allow and deny patterns, and from-key=to-key rewrites
+func ParseLabelRules(allow string, deny string, rewrite string) (*LabelRules, error) {
+	rules := &LabelRules{
+		Allow:   splitList(allow),
+		Deny:    splitList(deny),
+		Rewrite: map[string]string{},
+	}
+
+	for _, pattern := range append(append([]string{}, rules.Allow...), rules.Deny...) {
+		if errs := validation.IsQualifiedName(strings.ReplaceAll(pattern, "*", "x")); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid label key pattern %q: %s", pattern, strings.Join(errs, ", "))
+		}
+	}
+
+	for _, item := range splitList(rewrite) {
+		from, to, found := strings.Cut(item, "=")
+		from = strings.TrimSpace(from)
+		to = strings.TrimSpace(to)
+		if !found || from == "" || to == "" {
+			return nil, fmt.Errorf("invalid label rewrite %q, expected from-key=to-key", item)
+		}
+		if errs := validation.IsQualifiedName(to); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid label key %q in rewrite %q: %s", to, item, strings.Join(errs, ", "))
+		}
+		rules.Rewrite[from] = to
+	}
+	return rules, nil
+}
+
+// matchLabelKey reports whether the label key matches the glob pattern
+func matchLabelKey(pattern string, key string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == key
+	}
+
+	if !strings.HasPrefix(key, parts[0]) {
+		return false
+	}
+	key = key[len(parts[0]):]
+
+	last := parts[len(parts)-1]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(key, part)
+		if i < 0 {
+			return false
+		}
+		key = key[i+len(part):]
+	}
+	return len(key) >= len(last) && strings.HasSuffix(key, last)
+}
+
+// matchAnyLabelKey reports whether the label key matches one of the glob patterns
+func matchAnyLabelKey(patterns []string, key string) bool {
+	for _, pattern := range patterns {
+		if matchLabelKey(pattern, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterLabels applies the label rules to the labels flowing from the claim (or its pool) to the ManagedCluster.
+// A label set on the claim with the rewritten key wins over the rewritten label.
+func filterLabels(r *ClusterClaimsReconciler, labels map[string]string) map[string]string {
+	rules := r.LabelRules
+	if rules == nil {
+		return labels
+	}
+
+	filtered := map[string]string{}
+	rewritten := map[string]string{}
+	for key, val := range labels {
+		if len(rules.Allow) > 0 && !matchAnyLabelKey(rules.Allow, key) {
+			r.Log.V(DEBUG).Info("Label: " + key + " is not allowed, skip")
+			continue
+		}
+		if matchAnyLabelKey(rules.Deny, key) {
+			r.Log.V(DEBUG).Info("Label: " + key + " is denied, skip")
+			continue
+		}
+		if to, found := rules.Rewrite[key]; found {
+			r.Log.V(DEBUG).Info("Rewrite label: " + key + " to " + to)
+			rewritten[to] = val
+			continue
+		}
+		filtered[key] = val
+	}
+
+	for key, val := range rewritten {
+		if _, found := filtered[key]; !found {
+			filtered[key] = val
+		}
+	}
+	return filtered
+}
+
+// setPoolDefaultLabels adds the default labels of the claim's pool to the claim labels, a label set on the claim wins.
+// The defaults are saved on the claim, with the pool-default-labels annotation recording the ones that came from the
+// pool, so they follow the pool when its defaults change. A recorded label its author changed is theirs from then on.
+// Like the clusterset label of the pool, a default clusterset is not saved, a clusterset stored on the claim is
+// always one pinned by its author.
+func setPoolDefaultLabels(r *ClusterClaimsReconciler, cc *hivev1.ClusterClaim) error {
+	cp, err := getClusterPool(r, cc)
+	if err != nil || cp == nil {
+		return err
+	}
+
+	defaults := map[string]string{}
+	for _, item := range splitList(cp.Annotations[DEFAULT_LABELS]) {
+		key, val, found := strings.Cut(item, "=")
+		key = strings.TrimSpace(key)
+		val = strings.TrimSpace(val)
+		if !found || len(validation.IsQualifiedName(key)) > 0 || len(validation.IsValidLabelValue(val)) > 0 {
+			r.Log.V(WARN).Info("Skip invalid default label: " + item + " on cluster pool: " + cp.Name)
+			continue
+		}
+		defaults[key] = val
+	}
+
+	if cc.Labels == nil {
+		cc.Labels = make(map[string]string)
+	}
+	original := cc.DeepCopy()
+	persisted := cc.DeepCopy()
+
+	recorded := map[string]string{}
+	for key, val := range getPoolDefaultLabels(cc) {
+		if current, exists := cc.Labels[key]; !exists || current != val {
+			if exists {
+				r.Log.V(DEBUG).Info("Default label: " + key + " was changed on cluster claim: " + cc.Name + ", keep it")
+			}
+			continue
+		}
+		if _, found := defaults[key]; !found {
+			r.Log.V(DEBUG).Info("Remove default label: " + key + " from cluster claim: " + cc.Name)
+			delete(cc.Labels, key)
+			delete(persisted.Labels, key)
+			continue
+		}
+		recorded[key] = val
+	}
+
+	for key, val := range defaults {
+		if _, exists := cc.Labels[key]; exists {
+			if _, found := recorded[key]; !found {
+				continue
+			}
+		}
+		r.Log.V(DEBUG).Info("Default label: " + key + "=" + val + " from cluster pool: " + cp.Name)
+		cc.Labels[key] = val
+		if key == ClusterSetLabel {
+			continue
+		}
+		persisted.Labels[key] = val
+		recorded[key] = val
+	}
+
+	setPoolDefaultLabelsAnnotation(persisted, recorded)
+
+	if equality.Semantic.DeepEqual(original.Labels, persisted.Labels) &&
+		equality.Semantic.DeepEqual(original.Annotations, persisted.Annotations) {
+		return nil
+	}
+
+	r.Log.V(INFO).Info("Save default labels on cluster claim: " + cc.Name)
+	if err := r.Patch(context.Background(), persisted, client.MergeFrom(original)); err != nil {
+		return err
+	}
+	cc.Annotations = persisted.Annotations
+	cc.ResourceVersion = persisted.ResourceVersion
+	return nil
+}
+
+// getPoolDefaultLabels returns the labels recorded as saved from the pool defaults on the claim
+func getPoolDefaultLabels(cc *hivev1.ClusterClaim) map[string]string {
+	labels := map[string]string{}
+	for _, item := range splitList(cc.Annotations[POOL_DEFAULT_LABELS]) {
+		if key, val, found := strings.Cut(item, "="); found {
+			labels[key] = val
+		}
+	}
+	return labels
+}
+
+// setPoolDefaultLabelsAnnotation records the labels saved from the pool defaults on the claim, the annotation
+// is removed when there are none
+func setPoolDefaultLabelsAnnotation(cc *hivev1.ClusterClaim, labels map[string]string) {
+	if len(labels) == 0 {
+		delete(cc.Annotations, POOL_DEFAULT_LABELS)
+		return
+	}
+
+	items := make([]string, 0, len(labels))
+	for key, val := range labels {
+		items = append(items, key+"="+val)
+	}
+	sort.Strings(items)
+
+	if cc.Annotations == nil {
+		cc.Annotations = map[string]string{}
+	}
+	cc.Annotations[POOL_DEFAULT_LABELS] = strings.Join(items, ",")
+}
+
+// syncManagedClusterSet moves the imported ManagedCluster to the clusterset of the claim, which is the clusterset of
+// its pool unless the claim pins its own. Only a ManagedCluster created for the claim is moved, and it is left in
+// its set when neither the claim nor the pool name one.
+func syncManagedClusterSet(r *ClusterClaimsReconciler, cc *hivev1.ClusterClaim) error {
+	ctx := context.Background()
+	log := r.Log
+
+	clusterSet := cc.Labels[ClusterSetLabel]
+	if clusterSet == "" {
+		return nil
+	}
+
+	var mc mcv1.ManagedCluster
+	if err := r.Get(ctx, types.NamespacedName{Name: cc.Spec.Namespace}, &mc); err != nil {
+		if k8serrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	if mc.DeletionTimestamp != nil || mc.Labels[ClusterSetLabel] == clusterSet {
+		return nil
+	}
+
+	if owner, reason := isClaimOwner(&mc, cc); !owner {
+		log.V(DEBUG).Info("ManagedCluster: " + mc.Name + " is not owned by the claim, " + reason + ", skip clusterset sync")
+		return nil
+	}
+
+	original := mc.DeepCopy()
+	if mc.Labels == nil {
+		mc.Labels = map[string]string{}
+	}
+	mc.Labels[ClusterSetLabel] = clusterSet
+
+	log.V(INFO).Info("Move ManagedCluster: " + mc.Name + " to ManagedClusterSet: " + clusterSet)
+	return r.Patch(ctx, &mc, client.MergeFrom(original))
+}
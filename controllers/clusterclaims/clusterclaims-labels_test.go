@@ -0,0 +1,403 @@
+package clusterlcaims
+
+import (
+	"context"
+	"testing"
+
+	hivev1 "github.com/openshift/hive/apis/hive/v1"
+	"github.com/stretchr/testify/assert"
+	mcv1 "open-cluster-management.io/api/cluster/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestReconcileClusterClaimsManagedLabelsRecorded(t *testing.T) {
+
+	ctx := context.Background()
+
+	ccr := GetClusterClaimsReconciler()
+
+	ccr.Client.Create(ctx, GetClusterClaim(CC_NAMESPACE, CC_NAME, CLUSTER01), &client.CreateOptions{})
+
+	_, err := ccr.Reconcile(ctx, getRequest())
+	assert.Nil(t, err, "nil, when clusterClaim is found reconcile was successful")
+
+	var mc mcv1.ManagedCluster
+	err = ccr.Client.Get(ctx, getNamespaceName("", CLUSTER01), &mc)
+	assert.Nil(t, err, "nil, when managedCluster resource is retrieved")
+
+	keys, found := getManagedLabelKeys(&mc)
+	assert.True(t, found, "managed labels annotation should be set")
+	assert.True(t, keys["usage"], "claim label usage should be managed")
+	assert.False(t, keys["vendor"], "label vendor is not copied from the claim")
+}
+
+func TestReconcileClusterClaimsSyncLabels(t *testing.T) {
+
+	ctx := context.Background()
+
+	ccr := GetClusterClaimsReconciler()
+	ccr.SyncLabels = true
+
+	cc := GetClusterClaim(CC_NAMESPACE, CC_NAME, CLUSTER01)
+	cc.Labels["team"] = "red"
+	ccr.Client.Create(ctx, cc, &client.CreateOptions{})
+
+	_, err := ccr.Reconcile(ctx, getRequest())
+	assert.Nil(t, err, "nil, when clusterClaim is found reconcile was successful")
+
+	// Another actor sets its own label on the ManagedCluster
+	var mc mcv1.ManagedCluster
+	err = ccr.Client.Get(ctx, getNamespaceName("", CLUSTER01), &mc)
+	assert.Nil(t, err, "nil, when managedCluster resource is retrieved")
+	mc.Labels["owner"] = "someone-else"
+	err = ccr.Client.Update(ctx, &mc)
+	assert.Nil(t, err, "nil, when managedCluster resource is updated")
+
+	// Relabel the claim
+	err = ccr.Client.Get(ctx, getNamespaceName(CC_NAMESPACE, CC_NAME), cc)
+	assert.Nil(t, err, "nil, when clusterClaim is retrieved")
+	cc.Labels["usage"] = "staging"
+	cc.Labels["owner"] = "claim"
+	delete(cc.Labels, "team")
+	err = ccr.Client.Update(ctx, cc)
+	assert.Nil(t, err, "nil, when clusterClaim is updated")
+
+	_, err = ccr.Reconcile(ctx, getRequest())
+	assert.Nil(t, err, "nil, when clusterClaim is found reconcile was successful")
+
+	err = ccr.Client.Get(ctx, getNamespaceName("", CLUSTER01), &mc)
+	assert.Nil(t, err, "nil, when managedCluster resource is retrieved")
+
+	assert.Equal(t, "staging", mc.Labels["usage"], "label usage should follow the claim")
+	assert.NotContains(t, mc.Labels, "team", "label team should be removed with the claim label")
+	assert.Equal(t, "someone-else", mc.Labels["owner"], "label owner is not managed by the claim")
+	assert.Equal(t, "OpenShift", mc.Labels["vendor"], "label vendor is not managed by the claim")
+
+	keys, _ := getManagedLabelKeys(&mc)
+	assert.False(t, keys["owner"], "label owner should not be taken over")
+}
+
+func TestReconcileClusterClaimsSyncLabelsDisabled(t *testing.T) {
+
+	ctx := context.Background()
+
+	ccr := GetClusterClaimsReconciler()
+
+	ccr.Client.Create(ctx, GetClusterClaim(CC_NAMESPACE, CC_NAME, CLUSTER01), &client.CreateOptions{})
+
+	_, err := ccr.Reconcile(ctx, getRequest())
+	assert.Nil(t, err, "nil, when clusterClaim is found reconcile was successful")
+
+	var cc hivev1.ClusterClaim
+	err = ccr.Client.Get(ctx, getNamespaceName(CC_NAMESPACE, CC_NAME), &cc)
+	assert.Nil(t, err, "nil, when clusterClaim is retrieved")
+	cc.Labels["usage"] = "staging"
+	err = ccr.Client.Update(ctx, &cc)
+	assert.Nil(t, err, "nil, when clusterClaim is updated")
+
+	_, err = ccr.Reconcile(ctx, getRequest())
+	assert.Nil(t, err, "nil, when clusterClaim is found reconcile was successful")
+
+	var mc mcv1.ManagedCluster
+	err = ccr.Client.Get(ctx, getNamespaceName("", CLUSTER01), &mc)
+	assert.Nil(t, err, "nil, when managedCluster resource is retrieved")
+	assert.Equal(t, "production", mc.Labels["usage"], "label usage should not change without sync")
+}
+
+func TestSyncLabelsSkipsUntrackedManagedCluster(t *testing.T) {
+
+	ctx := context.Background()
+
+	ccr := GetClusterClaimsReconciler()
+	ccr.SyncLabels = true
+
+	cc := GetClusterClaim(CC_NAMESPACE, CC_NAME, CLUSTER01)
+
+	mc := &mcv1.ManagedCluster{}
+	mc.Name = CLUSTER01
+	ccr.Client.Create(ctx, mc, &client.CreateOptions{})
+
+	err := syncManagedClusterLabels(ccr, cc)
+	assert.Nil(t, err, "nil, when the ManagedCluster is skipped")
+
+	err = ccr.Client.Get(ctx, getNamespaceName("", CLUSTER01), mc)
+	assert.Nil(t, err, "nil, when managedCluster resource is retrieved")
+	assert.NotContains(t, mc.Labels, "usage", "labels should not be added to a ManagedCluster the controller did not create")
+}
+
+func TestMatchLabelKey(t *testing.T) {
+
+	assert.True(t, matchLabelKey("team", "team"), "exact key")
+	assert.False(t, matchLabelKey("team", "example.com/team"), "exact key only")
+	assert.True(t, matchLabelKey("*", "example.com/team"), "* matches the prefix separator")
+	assert.True(t, matchLabelKey("app.kubernetes.io/*", "app.kubernetes.io/instance"), "prefix pattern")
+	assert.False(t, matchLabelKey("app.kubernetes.io/*", "argocd.argoproj.io/instance"), "other prefix")
+	assert.True(t, matchLabelKey("*/instance", "argocd.argoproj.io/instance"), "suffix pattern")
+	assert.True(t, matchLabelKey("a*b*c", "a-b-c"), "several wildcards")
+	assert.False(t, matchLabelKey("ab*bc", "abc"), "prefix and suffix do not overlap")
+}
+
+func TestParseLabelRules(t *testing.T) {
+
+	rules, err := ParseLabelRules("usage, team", "app.kubernetes.io/*", "team=example.com/team")
+	assert.Nil(t, err, "nil, when the rules are valid")
+	assert.Equal(t, []string{"usage", "team"}, rules.Allow, "allow patterns")
+	assert.Equal(t, []string{"app.kubernetes.io/*"}, rules.Deny, "deny patterns")
+	assert.Equal(t, map[string]string{"team": "example.com/team"}, rules.Rewrite, "rewrites")
+
+	_, err = ParseLabelRules("", "", "team")
+	assert.NotNil(t, err, "a rewrite needs a target key")
+
+	_, err = ParseLabelRules("", "", "team=not a key")
+	assert.NotNil(t, err, "the rewrite target must be a valid label key")
+
+	_, err = ParseLabelRules("bad key*", "", "")
+	assert.NotNil(t, err, "patterns must look like label keys")
+}
+
+func TestReconcileClusterClaimsLabelRules(t *testing.T) {
+
+	ctx := context.Background()
+
+	ccr := GetClusterClaimsReconciler()
+	ccr.SyncLabels = true
+	ccr.LabelRules = &LabelRules{
+		Deny:    []string{"app.kubernetes.io/*"},
+		Rewrite: map[string]string{"team": "example.com/team"},
+	}
+
+	cc := GetClusterClaim(CC_NAMESPACE, CC_NAME, CLUSTER01)
+	cc.Labels["team"] = "red"
+	cc.Labels["app.kubernetes.io/instance"] = "cluster-claims"
+	ccr.Client.Create(ctx, cc, &client.CreateOptions{})
+
+	_, err := ccr.Reconcile(ctx, getRequest())
+	assert.Nil(t, err, "nil, when clusterClaim is found reconcile was successful")
+
+	var mc mcv1.ManagedCluster
+	err = ccr.Client.Get(ctx, getNamespaceName("", CLUSTER01), &mc)
+	assert.Nil(t, err, "nil, when managedCluster resource is retrieved")
+	assert.NotContains(t, mc.Labels, "app.kubernetes.io/instance", "denied label is not copied")
+	assert.NotContains(t, mc.Labels, "team", "rewritten label is not copied as is")
+	assert.Equal(t, "red", mc.Labels["example.com/team"], "rewritten label")
+	assert.Equal(t, "production", mc.Labels["usage"], "other labels are copied")
+
+	keys, _ := getManagedLabelKeys(&mc)
+	assert.True(t, keys["example.com/team"], "the rewritten key is managed")
+
+	// Deny the usage label after import, the sync removes it
+	ccr.LabelRules.Deny = append(ccr.LabelRules.Deny, "usage")
+	_, err = ccr.Reconcile(ctx, getRequest())
+	assert.Nil(t, err, "nil, when clusterClaim is found reconcile was successful")
+
+	err = ccr.Client.Get(ctx, getNamespaceName("", CLUSTER01), &mc)
+	assert.Nil(t, err, "nil, when managedCluster resource is retrieved")
+	assert.NotContains(t, mc.Labels, "usage", "newly denied label is removed by the sync")
+	assert.Equal(t, "red", mc.Labels["example.com/team"], "rewritten label is kept")
+}
+
+func TestFilterLabelsAllow(t *testing.T) {
+
+	ccr := GetClusterClaimsReconciler()
+	ccr.LabelRules = &LabelRules{
+		Allow:   []string{"usage", "team", "example.com/*"},
+		Deny:    []string{"example.com/secret"},
+		Rewrite: map[string]string{"team": "example.com/team"},
+	}
+
+	labels := filterLabels(ccr, map[string]string{
+		"usage":              "production",
+		"env":                "dev",
+		"team":               "red",
+		"example.com/team":   "blue",
+		"example.com/secret": "shh",
+	})
+	assert.Equal(t, map[string]string{
+		"usage":            "production",
+		"example.com/team": "blue",
+	}, labels, "allow and deny apply, the label set on the claim wins over the rewritten one")
+
+	ccr.LabelRules = nil
+	assert.Equal(t, map[string]string{"env": "dev"}, filterLabels(ccr, map[string]string{"env": "dev"}), "no rules copy all")
+}
+
+func TestReconcileClusterClaimsPoolDefaultLabels(t *testing.T) {
+
+	ctx := context.Background()
+
+	ccr := GetClusterClaimsReconciler()
+	ccr.SyncLabels = true
+
+	cc := GetClusterClaim(CC_NAMESPACE, CC_NAME, CLUSTER01)
+	cp := GetClusterPool(CC_NAMESPACE, cc.Spec.ClusterPoolName, nil)
+	cp.Annotations = map[string]string{DEFAULT_LABELS: "environment=perf, cost-center=cc-1234, usage=shared, bad key=x, team"}
+	ccr.Client.Create(ctx, cp, &client.CreateOptions{})
+	ccr.Client.Create(ctx, cc, &client.CreateOptions{})
+
+	_, err := ccr.Reconcile(ctx, getRequest())
+	assert.Nil(t, err, "nil, when clusterClaim is found reconcile was successful")
+
+	var mc mcv1.ManagedCluster
+	err = ccr.Client.Get(ctx, getNamespaceName("", CLUSTER01), &mc)
+	assert.Nil(t, err, "nil, when managedCluster resource is retrieved")
+	assert.Equal(t, "perf", mc.Labels["environment"], "default label from the pool")
+	assert.Equal(t, "cc-1234", mc.Labels["cost-center"], "default label from the pool")
+	assert.Equal(t, "production", mc.Labels["usage"], "the claim label takes precedence")
+	assert.NotContains(t, mc.Labels, "bad key", "invalid default labels are skipped")
+	assert.NotContains(t, mc.Labels, "team", "a default label without a value is skipped")
+
+	// A new pool default reaches the imported cluster with the label sync
+	err = ccr.Client.Get(ctx, getNamespaceName(CC_NAMESPACE, cc.Spec.ClusterPoolName), cp)
+	assert.Nil(t, err, "nil, when clusterPool is retrieved")
+	cp.Annotations[DEFAULT_LABELS] = "environment=perf,cost-center=cc-5678"
+	err = ccr.Client.Update(ctx, cp)
+	assert.Nil(t, err, "nil, when clusterPool is updated")
+
+	_, err = ccr.Reconcile(ctx, getRequest())
+	assert.Nil(t, err, "nil, when clusterClaim is found reconcile was successful")
+
+	err = ccr.Client.Get(ctx, getNamespaceName("", CLUSTER01), &mc)
+	assert.Nil(t, err, "nil, when managedCluster resource is retrieved")
+	assert.Equal(t, "cc-5678", mc.Labels["cost-center"], "updated pool default label")
+
+	err = ccr.Client.Get(ctx, getNamespaceName(CC_NAMESPACE, CC_NAME), cc)
+	assert.Nil(t, err, "nil, when clusterClaim is retrieved")
+	assert.Equal(t, "perf", cc.Labels["environment"], "the defaults are saved on the claim")
+	assert.Equal(t, "cost-center=cc-5678,environment=perf", cc.Annotations[POOL_DEFAULT_LABELS],
+		"the labels from the pool are recorded")
+
+	// The author takes over a default label, the pool drops the other one and defaults a clusterset
+	cc.Labels["cost-center"] = "cc-0001"
+	err = ccr.Client.Update(ctx, cc)
+	assert.Nil(t, err, "nil, when clusterClaim is updated")
+
+	err = ccr.Client.Get(ctx, getNamespaceName(CC_NAMESPACE, cc.Spec.ClusterPoolName), cp)
+	assert.Nil(t, err, "nil, when clusterPool is retrieved")
+	cp.Annotations[DEFAULT_LABELS] = "cost-center=cc-9999," + ClusterSetLabel + "=default-set"
+	err = ccr.Client.Update(ctx, cp)
+	assert.Nil(t, err, "nil, when clusterPool is updated")
+
+	_, err = ccr.Reconcile(ctx, getRequest())
+	assert.Nil(t, err, "nil, when clusterClaim is found reconcile was successful")
+
+	err = ccr.Client.Get(ctx, getNamespaceName(CC_NAMESPACE, CC_NAME), cc)
+	assert.Nil(t, err, "nil, when clusterClaim is retrieved")
+	assert.Equal(t, "cc-0001", cc.Labels["cost-center"], "the label changed by the author takes precedence")
+	assert.NotContains(t, cc.Labels, "environment", "the default dropped from the pool is removed")
+	assert.NotContains(t, cc.Labels, ClusterSetLabel, "a default clusterset is not saved on the claim")
+	assert.NotContains(t, cc.Annotations, POOL_DEFAULT_LABELS, "no label on the claim comes from the pool")
+
+	err = ccr.Client.Get(ctx, getNamespaceName("", CLUSTER01), &mc)
+	assert.Nil(t, err, "nil, when managedCluster resource is retrieved")
+	assert.Equal(t, "cc-0001", mc.Labels["cost-center"], "the label of the author is synced")
+	assert.NotContains(t, mc.Labels, "environment", "the dropped default is removed from the ManagedCluster")
+}
+
+func TestReconcileClusterClaimsPropagateClusterSet(t *testing.T) {
+
+	ctx := context.Background()
+
+	ccr := GetClusterClaimsReconciler()
+	ccr.PropagateClusterSet = true
+
+	cc := GetClusterClaim(CC_NAMESPACE, CC_NAME, CLUSTER01)
+	cp := GetClusterPool(CC_NAMESPACE, cc.Spec.ClusterPoolName, map[string]string{ClusterSetLabel: "old-set"})
+	ccr.Client.Create(ctx, cp, &client.CreateOptions{})
+	ccr.Client.Create(ctx, cc, &client.CreateOptions{})
+
+	// A second claim pins its own set
+	pinned := GetClusterClaim(CC_NAMESPACE, "pinned-claim", "cluster02")
+	pinned.Labels[ClusterSetLabel] = "pinned-set"
+	ccr.Client.Create(ctx, pinned, &client.CreateOptions{})
+
+	_, err := ccr.Reconcile(ctx, getRequest())
+	assert.Nil(t, err, "nil, when clusterClaim is found reconcile was successful")
+	_, err = ccr.Reconcile(ctx, getRequestWithNamespaceName(CC_NAMESPACE, "pinned-claim"))
+	assert.Nil(t, err, "nil, when clusterClaim is found reconcile was successful")
+
+	// Move the pool to a new set
+	err = ccr.Client.Get(ctx, getNamespaceName(CC_NAMESPACE, cc.Spec.ClusterPoolName), cp)
+	assert.Nil(t, err, "nil, when clusterPool is retrieved")
+	cp.Labels[ClusterSetLabel] = "new-set"
+	err = ccr.Client.Update(ctx, cp)
+	assert.Nil(t, err, "nil, when clusterPool is updated")
+
+	requests := claimRequestsForClusterPool(ccr, cp)
+	assert.Len(t, requests, 2, "both claims of the pool are reconciled")
+
+	for _, req := range requests {
+		_, err = ccr.Reconcile(ctx, req)
+		assert.Nil(t, err, "nil, when clusterClaim is found reconcile was successful")
+	}
+
+	var mc mcv1.ManagedCluster
+	err = ccr.Client.Get(ctx, getNamespaceName("", CLUSTER01), &mc)
+	assert.Nil(t, err, "nil, when managedCluster resource is retrieved")
+	assert.Equal(t, "new-set", mc.Labels[ClusterSetLabel], "the cluster follows its pool to the new set")
+
+	err = ccr.Client.Get(ctx, getNamespaceName("", "cluster02"), &mc)
+	assert.Nil(t, err, "nil, when managedCluster resource is retrieved")
+	assert.Equal(t, "pinned-set", mc.Labels[ClusterSetLabel], "the pinned claim keeps its set")
+}
+
+func TestReconcileClusterClaimsPropagateClusterSetDisabled(t *testing.T) {
+
+	ctx := context.Background()
+
+	ccr := GetClusterClaimsReconciler()
+
+	cc := GetClusterClaim(CC_NAMESPACE, CC_NAME, CLUSTER01)
+	cp := GetClusterPool(CC_NAMESPACE, cc.Spec.ClusterPoolName, map[string]string{ClusterSetLabel: "old-set"})
+	ccr.Client.Create(ctx, cp, &client.CreateOptions{})
+	ccr.Client.Create(ctx, cc, &client.CreateOptions{})
+
+	_, err := ccr.Reconcile(ctx, getRequest())
+	assert.Nil(t, err, "nil, when clusterClaim is found reconcile was successful")
+
+	cp.Labels[ClusterSetLabel] = "new-set"
+	err = ccr.Client.Update(ctx, cp)
+	assert.Nil(t, err, "nil, when clusterPool is updated")
+
+	_, err = ccr.Reconcile(ctx, getRequest())
+	assert.Nil(t, err, "nil, when clusterClaim is found reconcile was successful")
+
+	var mc mcv1.ManagedCluster
+	err = ccr.Client.Get(ctx, getNamespaceName("", CLUSTER01), &mc)
+	assert.Nil(t, err, "nil, when managedCluster resource is retrieved")
+	assert.Equal(t, "old-set", mc.Labels[ClusterSetLabel], "the cluster stays in its set by default")
+}
+
+func TestReconcileClusterClaimsSyncLabelsKeepsClusterSet(t *testing.T) {
+
+	ctx := context.Background()
+
+	ccr := GetClusterClaimsReconciler()
+	ccr.SyncLabels = true
+
+	cc := GetClusterClaim(CC_NAMESPACE, CC_NAME, CLUSTER01)
+	cp := GetClusterPool(CC_NAMESPACE, cc.Spec.ClusterPoolName, map[string]string{ClusterSetLabel: "old-set"})
+	ccr.Client.Create(ctx, cp, &client.CreateOptions{})
+	ccr.Client.Create(ctx, cc, &client.CreateOptions{})
+
+	_, err := ccr.Reconcile(ctx, getRequest())
+	assert.Nil(t, err, "nil, when clusterClaim is found reconcile was successful")
+
+	var mc mcv1.ManagedCluster
+	err = ccr.Client.Get(ctx, getNamespaceName("", CLUSTER01), &mc)
+	assert.Nil(t, err, "nil, when managedCluster resource is retrieved")
+	assert.Equal(t, "old-set", mc.Labels[ClusterSetLabel], "the cluster is created in the set of its pool")
+
+	keys, _ := getManagedLabelKeys(&mc)
+	assert.False(t, keys[ClusterSetLabel], "the clusterset label is not managed by the label sync")
+
+	cp.Labels[ClusterSetLabel] = "new-set"
+	err = ccr.Client.Update(ctx, cp)
+	assert.Nil(t, err, "nil, when clusterPool is updated")
+
+	_, err = ccr.Reconcile(ctx, getRequest())
+	assert.Nil(t, err, "nil, when clusterClaim is found reconcile was successful")
+
+	err = ccr.Client.Get(ctx, getNamespaceName("", CLUSTER01), &mc)
+	assert.Nil(t, err, "nil, when managedCluster resource is retrieved")
+	assert.Equal(t, "old-set", mc.Labels[ClusterSetLabel], "the label sync does not move the cluster to another set")
+}
@@ -0,0 +1,203 @@
+// Copyright Contributors to the Open Cluster Management project.
+
+package clusterlcaims
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	hivev1 "github.com/openshift/hive/apis/hive/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Cluster metadata that can be derived into labels, see ParseDerivedLabels
+const (
+	OpenShiftVersionField = "openshiftVersion"
+	InfraIDField          = "infraID"
+	ClusterIDField        = "clusterID"
+	BaseDomainField       = "baseDomain"
+	PlatformField         = "platform"
+)
+
+var derivedLabelFields = []string{OpenShiftVersionField, InfraIDField, ClusterIDField, BaseDomainField, PlatformField}
+
+// ParseDerivedLabels parses a comma separated list of field=label-key pairs, a field without a key
+// uses the field name as the label key. For example: "openshiftVersion=openshiftVersion,platform"
+func ParseDerivedLabels(value string) (map[string]string, error) {
+	derived := map[string]string{}
+	for _, item := range splitList(value) {
+		field, key, found := strings.Cut(item, "=")
+		field = strings.TrimSpace(field)
+		key = strings.TrimSpace(key)
+		if !found {
+			key = field
+		}
+
+		known := false
+		for _, f := range derivedLabelFields {
+			known = known || f == field
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown derived label field %q, expected one of %s",
+				field, strings.Join(derivedLabelFields, ", "))
+		}
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid label key %q for %s: %s", key, field, strings.Join(errs, ", "))
+		}
+		if strings.HasPrefix(key, CONTROLLER_PREFIX) {
+			return nil, fmt.Errorf("label key %q for %s is reserved for the clusterclaims controller", key, field)
+		}
+		derived[field] = key
+	}
+	return derived, nil
+}
+
+// getClaimClusterDeployment returns the claimed ClusterDeployment, nil when it does not exist
+func getClaimClusterDeployment(r *ClusterClaimsReconciler, cc *hivev1.ClusterClaim) (*hivev1.ClusterDeployment, error) {
+	clusterName := cc.Spec.Namespace
+
+	var cd hivev1.ClusterDeployment
+	if err := r.Get(
+		context.Background(),
+		types.NamespacedName{Namespace: clusterName, Name: clusterName}, &cd); err != nil {
+
+		if k8serrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &cd, nil
+}
+
+// setDerivedLabels adds the configured cluster metadata labels to the claim labels, they are looked up
+// like the region, from the ClusterDeployment or the ClusterPool. Values that are unknown or not valid
+// label values are skipped. The derived labels are also saved on the claim, when they change.
+func setDerivedLabels(r *ClusterClaimsReconciler, cc *hivev1.ClusterClaim) error {
+	if len(r.DerivedLabels) == 0 {
+		return nil
+	}
+
+	metadata, err := getClusterMetadata(r, cc)
+	if err != nil {
+		return err
+	}
+
+	if cc.Labels == nil {
+		cc.Labels = make(map[string]string)
+	}
+	original := cc.DeepCopy()
+	persisted := cc.DeepCopy()
+
+	fields := make([]string, 0, len(r.DerivedLabels))
+	for field := range r.DerivedLabels {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	for _, field := range fields {
+		key := r.DerivedLabels[field]
+		value := metadata[field]
+		if value == "" {
+			continue
+		}
+		if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+			r.Log.V(WARN).Info("Skip label: " + key + ", \"" + value + "\" is not a valid label value")
+			continue
+		}
+		r.Log.V(DEBUG).Info("Derived label: " + key + "=" + value)
+		cc.Labels[key] = value
+		persisted.Labels[key] = value
+	}
+
+	if equality.Semantic.DeepEqual(original.Labels, persisted.Labels) {
+		return nil
+	}
+
+	// Patch a copy, so the labels only added in memory, like the region, are not replaced by the stored claim
+	r.Log.V(INFO).Info("Save derived labels on cluster claim: " + cc.Name)
+	if err := r.Patch(context.Background(), persisted, client.MergeFrom(original)); err != nil {
+		return err
+	}
+	cc.ResourceVersion = persisted.ResourceVersion
+	return nil
+}
+
+// getClusterMetadata returns the derivable metadata by field, from the ClusterDeployment when it exists
+func getClusterMetadata(r *ClusterClaimsReconciler, cc *hivev1.ClusterClaim) (map[string]string, error) {
+	metadata := map[string]string{}
+
+	cd, err := getClaimClusterDeployment(r, cc)
+	if err != nil {
+		return nil, err
+	}
+
+	imageSetName := ""
+	releaseImage := ""
+
+	if cd != nil {
+		metadata[BaseDomainField] = cd.Spec.BaseDomain
+		metadata[PlatformField] = getPlatformName(cd.Spec.Platform)
+		if cd.Spec.ClusterMetadata != nil {
+			metadata[InfraIDField] = cd.Spec.ClusterMetadata.InfraID
+			metadata[ClusterIDField] = cd.Spec.ClusterMetadata.ClusterID
+		}
+		if cd.Status.InstallVersion != nil {
+			metadata[OpenShiftVersionField] = *cd.Status.InstallVersion
+		}
+		if cd.Spec.Provisioning != nil {
+			releaseImage = cd.Spec.Provisioning.ReleaseImage
+			if cd.Spec.Provisioning.ImageSetRef != nil {
+				imageSetName = cd.Spec.Provisioning.ImageSetRef.Name
+			}
+		}
+	} else {
+		cp, err := getClusterPool(r, cc)
+		if err != nil {
+			return nil, err
+		}
+		if cp != nil {
+			metadata[BaseDomainField] = cp.Spec.BaseDomain
+			metadata[PlatformField] = getPlatformName(cp.Spec.Platform)
+			imageSetName = cp.Spec.ImageSetRef.Name
+		}
+	}
+
+	if metadata[OpenShiftVersionField] == "" {
+		if releaseImage == "" && imageSetName != "" {
+			var cis hivev1.ClusterImageSet
+			if err := r.Get(context.Background(), types.NamespacedName{Name: imageSetName}, &cis); err != nil {
+				if !k8serrors.IsNotFound(err) {
+					return nil, err
+				}
+				r.Log.V(WARN).Info("No ClusterImageSet found for " + imageSetName)
+			} else {
+				releaseImage = cis.Spec.ReleaseImage
+			}
+		}
+		metadata[OpenShiftVersionField] = getReleaseImageVersion(releaseImage)
+	}
+
+	return metadata, nil
+}
+
+// getReleaseImageVersion returns the version from a release image tag, for example 4.14.1 from
+// quay.io/openshift-release-dev/ocp-release:4.14.1-x86_64. Digest references have no version.
+func getReleaseImageVersion(releaseImage string) string {
+	if releaseImage == "" || strings.Contains(releaseImage, "@") {
+		return ""
+	}
+
+	i := strings.LastIndex(releaseImage, ":")
+	if i < 0 || strings.Contains(releaseImage[i:], "/") {
+		return ""
+	}
+
+	version, _, _ := strings.Cut(releaseImage[i+1:], "-")
+	return version
+}
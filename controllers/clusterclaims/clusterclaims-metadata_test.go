@@ -0,0 +1,145 @@
+package clusterlcaims
+
+import (
+	"context"
+	"testing"
+
+	hivev1 "github.com/openshift/hive/apis/hive/v1"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	mcv1 "open-cluster-management.io/api/cluster/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestParseDerivedLabels(t *testing.T) {
+
+	derived, err := ParseDerivedLabels("")
+	assert.Nil(t, err, "nil, an empty list is valid")
+	assert.Empty(t, derived, "no derived labels by default")
+
+	derived, err = ParseDerivedLabels("openshiftVersion=openshiftVersion-major-minor, platform ,infraID=example.com/infra-id")
+	assert.Nil(t, err, "nil, when the list is valid")
+	assert.Equal(t, map[string]string{
+		OpenShiftVersionField: "openshiftVersion-major-minor",
+		PlatformField:         "platform",
+		InfraIDField:          "example.com/infra-id",
+	}, derived, "fields map to their label keys")
+
+	_, err = ParseDerivedLabels("kubeVersion")
+	assert.NotNil(t, err, "unknown fields are rejected")
+
+	_, err = ParseDerivedLabels("clusterID=not a key")
+	assert.NotNil(t, err, "invalid label keys are rejected")
+
+	_, err = ParseDerivedLabels("clusterID=" + CONTROLLER_PREFIX + "cluster-id")
+	assert.NotNil(t, err, "label keys of the controller are rejected")
+}
+
+func TestGetReleaseImageVersion(t *testing.T) {
+
+	assert.Equal(t, "4.14.1", getReleaseImageVersion("quay.io/openshift-release-dev/ocp-release:4.14.1-x86_64"), "version from the tag")
+	assert.Equal(t, "4.15.0", getReleaseImageVersion("registry.example.com:5000/ocp-release:4.15.0"), "registry port is not a tag")
+	assert.Equal(t, "", getReleaseImageVersion("registry.example.com:5000/ocp-release"), "no tag")
+	assert.Equal(t, "", getReleaseImageVersion("quay.io/openshift-release-dev/ocp-release@sha256:0123abcd"), "digests have no version")
+	assert.Equal(t, "", getReleaseImageVersion(""), "no release image")
+}
+
+func TestReconcileClusterClaimsDerivedLabels(t *testing.T) {
+
+	ctx := context.Background()
+
+	ccr := GetClusterClaimsReconciler()
+	ccr.DerivedLabels = map[string]string{
+		OpenShiftVersionField: "openshiftVersion",
+		InfraIDField:          "infraID",
+		ClusterIDField:        "clusterID",
+		BaseDomainField:       "baseDomain",
+		PlatformField:         "platform",
+	}
+
+	ccr.Client.Create(ctx, GetClusterClaim(CC_NAMESPACE, CC_NAME, CLUSTER01), &client.CreateOptions{})
+
+	version := "4.14.1"
+	cd := GetClusterDeployment(CLUSTER01, "aws")
+	cd.Spec.BaseDomain = "demo.example.com"
+	cd.Spec.ClusterMetadata = &hivev1.ClusterMetadata{InfraID: "cluster01-x7k2p", ClusterID: "1c8f9a2e-0b4d-4e61-9f5a-3d2b7c6e8a10"}
+	cd.Status.InstallVersion = &version
+	ccr.Client.Create(ctx, cd, &client.CreateOptions{})
+
+	_, err := ccr.Reconcile(ctx, getRequest())
+	assert.Nil(t, err, "nil, when clusterClaim is found reconcile was successful")
+
+	var mc mcv1.ManagedCluster
+	err = ccr.Client.Get(ctx, getNamespaceName("", CLUSTER01), &mc)
+	assert.Nil(t, err, "nil, when managedCluster resource is retrieved")
+
+	assert.Equal(t, "4.14.1", mc.Labels["openshiftVersion"], "version from the install version")
+	assert.Equal(t, "cluster01-x7k2p", mc.Labels["infraID"], "infraID from the cluster metadata")
+	assert.Equal(t, "1c8f9a2e-0b4d-4e61-9f5a-3d2b7c6e8a10", mc.Labels["clusterID"], "clusterID from the cluster metadata")
+	assert.Equal(t, "demo.example.com", mc.Labels["baseDomain"], "baseDomain from the ClusterDeployment")
+	assert.Equal(t, "aws", mc.Labels["platform"], "platform from the ClusterDeployment")
+
+	var cc hivev1.ClusterClaim
+	err = ccr.Client.Get(ctx, getNamespaceName(CC_NAMESPACE, CC_NAME), &cc)
+	assert.Nil(t, err, "nil, when clusterClaim is retrieved")
+	assert.Equal(t, "4.14.1", cc.Labels["openshiftVersion"], "derived labels are saved on the claim")
+	assert.Equal(t, "aws", cc.Labels["platform"], "derived labels are saved on the claim")
+	assert.NotContains(t, cc.Labels, REGION_LABEL, "the region is only added to the ManagedCluster")
+}
+
+func TestReconcileClusterClaimsDerivedLabelsFromPool(t *testing.T) {
+
+	ctx := context.Background()
+
+	ccr := GetClusterClaimsReconciler()
+	ccr.DerivedLabels = map[string]string{
+		OpenShiftVersionField: "openshiftVersion",
+		InfraIDField:          "infraID",
+		BaseDomainField:       "baseDomain",
+	}
+
+	cc := GetClusterClaim(CC_NAMESPACE, CC_NAME, CLUSTER01)
+	cp := GetClusterPool(CC_NAMESPACE, cc.Spec.ClusterPoolName, nil)
+	cp.Spec.BaseDomain = "pool.example.com"
+	cp.Spec.ImageSetRef = hivev1.ClusterImageSetReference{Name: "img4.13.10"}
+	ccr.Client.Create(ctx, cp, &client.CreateOptions{})
+	ccr.Client.Create(ctx, cc, &client.CreateOptions{})
+
+	cis := &hivev1.ClusterImageSet{
+		ObjectMeta: v1.ObjectMeta{Name: "img4.13.10"},
+		Spec:       hivev1.ClusterImageSetSpec{ReleaseImage: "quay.io/openshift-release-dev/ocp-release:4.13.10-multi"},
+	}
+	ccr.Client.Create(ctx, cis, &client.CreateOptions{})
+
+	_, err := ccr.Reconcile(ctx, getRequest())
+	assert.Nil(t, err, "nil, when clusterClaim is found reconcile was successful")
+
+	var mc mcv1.ManagedCluster
+	err = ccr.Client.Get(ctx, getNamespaceName("", CLUSTER01), &mc)
+	assert.Nil(t, err, "nil, when managedCluster resource is retrieved")
+
+	assert.Equal(t, "4.13.10", mc.Labels["openshiftVersion"], "version from the pool ClusterImageSet")
+	assert.Equal(t, "pool.example.com", mc.Labels["baseDomain"], "baseDomain from the pool")
+	assert.NotContains(t, mc.Labels, "infraID", "no infraID until the ClusterDeployment exists")
+}
+
+func TestReconcileClusterClaimsNoDerivedLabels(t *testing.T) {
+
+	ctx := context.Background()
+
+	ccr := GetClusterClaimsReconciler()
+
+	ccr.Client.Create(ctx, GetClusterClaim(CC_NAMESPACE, CC_NAME, CLUSTER01), &client.CreateOptions{})
+
+	cd := GetClusterDeployment(CLUSTER01, "aws")
+	cd.Spec.BaseDomain = "demo.example.com"
+	ccr.Client.Create(ctx, cd, &client.CreateOptions{})
+
+	_, err := ccr.Reconcile(ctx, getRequest())
+	assert.Nil(t, err, "nil, when clusterClaim is found reconcile was successful")
+
+	var mc mcv1.ManagedCluster
+	err = ccr.Client.Get(ctx, getNamespaceName("", CLUSTER01), &mc)
+	assert.Nil(t, err, "nil, when managedCluster resource is retrieved")
+	assert.NotContains(t, mc.Labels, "baseDomain", "derived labels are off by default")
+}
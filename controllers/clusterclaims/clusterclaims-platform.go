@@ -0,0 +1,106 @@
+// Copyright Contributors to the Open Cluster Management project.
+
+package clusterlcaims
+
+import (
+	hivev1 "github.com/openshift/hive/apis/hive/v1"
+)
+
+// Labels derived from the ClusterDeployment platform
+const REGION_LABEL = "region"
+const DATACENTER_LABEL = "datacenter"
+const CLOUD_LABEL = "cloud"
+const VENDOR_LABEL = "vendor"
+
+// CLOUD_AUTO_DETECT leaves the cloud detection to the hub, for platforms that do not tell the provider
+const CLOUD_AUTO_DETECT = "auto-detect"
+
+// VENDOR_OPENSHIFT is the vendor of every cluster installed by Hive
+const VENDOR_OPENSHIFT = "OpenShift"
+
+// getClaimPlatform returns the platform of the claimed ClusterDeployment, or of the ClusterPool while the
+// ClusterDeployment can not be read. An empty platform is returned when neither is found.
+func getClaimPlatform(r *ClusterClaimsReconciler, cc *hivev1.ClusterClaim) (hivev1.Platform, error) {
+	cd, err := getClaimClusterDeployment(r, cc)
+	if err != nil {
+		return hivev1.Platform{}, err
+	} else if cd != nil {
+		return cd.Spec.Platform, nil
+	}
+	r.Log.V(WARN).Info("No ClusterDeployment found for " + cc.Spec.Namespace)
+
+	cp, err := getClusterPool(r, cc)
+	if err != nil || cp == nil {
+		return hivev1.Platform{}, err
+	}
+	return cp.Spec.Platform, nil
+}
+
+// getCloud returns the value of the ManagedCluster cloud label for the platform
+func getCloud(platform hivev1.Platform) string {
+	switch {
+	case platform.AWS != nil:
+		return "Amazon"
+	case platform.Azure != nil:
+		return "Azure"
+	case platform.GCP != nil:
+		return "Google"
+	case platform.IBMCloud != nil:
+		return "IBM"
+	case platform.VSphere != nil:
+		return "VSphere"
+	case platform.OpenStack != nil:
+		return "OpenStack"
+	case platform.Nutanix != nil:
+		return "Nutanix"
+	case platform.BareMetal != nil, platform.AgentBareMetal != nil:
+		return "BareMetal"
+	}
+	return CLOUD_AUTO_DETECT
+}
+
+// getPlatformName returns the name of the Hive platform, an empty string when no platform is set
+func getPlatformName(platform hivev1.Platform) string {
+	switch {
+	case platform.AWS != nil:
+		return "aws"
+	case platform.Azure != nil:
+		return "azure"
+	case platform.GCP != nil:
+		return "gcp"
+	case platform.IBMCloud != nil:
+		return "ibmcloud"
+	case platform.VSphere != nil:
+		return "vsphere"
+	case platform.OpenStack != nil:
+		return "openstack"
+	case platform.Nutanix != nil:
+		return "nutanix"
+	case platform.BareMetal != nil:
+		return "baremetal"
+	case platform.AgentBareMetal != nil:
+		return "agent-baremetal"
+	case platform.None != nil:
+		return "none"
+	}
+	return ""
+}
+
+// getPlatformLocation returns the region and datacenter of the platform. Public clouds have a region,
+// vSphere only has a datacenter. OpenStack (region is in clouds.yaml), Nutanix, bare metal and
+// platform agnostic installs have neither, both are returned empty.
+func getPlatformLocation(platform hivev1.Platform) (region string, datacenter string) {
+	switch {
+	case platform.AWS != nil:
+		return platform.AWS.Region, ""
+	case platform.Azure != nil:
+		return platform.Azure.Region, ""
+	case platform.GCP != nil:
+		return platform.GCP.Region, ""
+	case platform.IBMCloud != nil:
+		return platform.IBMCloud.Region, ""
+	case platform.VSphere != nil:
+		return "", platform.VSphere.Datacenter
+	}
+	return "", ""
+}
@@ -0,0 +1,160 @@
+package clusterlcaims
+
+import (
+	"context"
+	"testing"
+
+	hivev1 "github.com/openshift/hive/apis/hive/v1"
+	"github.com/openshift/hive/apis/hive/v1/agent"
+	"github.com/openshift/hive/apis/hive/v1/aws"
+	"github.com/openshift/hive/apis/hive/v1/azure"
+	"github.com/openshift/hive/apis/hive/v1/baremetal"
+	"github.com/openshift/hive/apis/hive/v1/gcp"
+	"github.com/openshift/hive/apis/hive/v1/ibmcloud"
+	"github.com/openshift/hive/apis/hive/v1/none"
+	"github.com/openshift/hive/apis/hive/v1/nutanix"
+	"github.com/openshift/hive/apis/hive/v1/openstack"
+	"github.com/openshift/hive/apis/hive/v1/vsphere"
+	"github.com/stretchr/testify/assert"
+	mcv1 "open-cluster-management.io/api/cluster/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var platformTests = []struct {
+	name       string
+	platform   hivev1.Platform
+	region     string
+	datacenter string
+	cloud      string
+}{
+	{"aws", hivev1.Platform{AWS: &aws.Platform{Region: "us-east-1"}}, "us-east-1", "", "Amazon"},
+	{"azure", hivev1.Platform{Azure: &azure.Platform{Region: "centralus"}}, "centralus", "", "Azure"},
+	{"gcp", hivev1.Platform{GCP: &gcp.Platform{Region: "europe-west3"}}, "europe-west3", "", "Google"},
+	{"ibmcloud", hivev1.Platform{IBMCloud: &ibmcloud.Platform{Region: "us-south"}}, "us-south", "", "IBM"},
+	{"vsphere", hivev1.Platform{VSphere: &vsphere.Platform{Datacenter: "dc-east"}}, "", "dc-east", "VSphere"},
+	{"openstack", hivev1.Platform{OpenStack: &openstack.Platform{Cloud: "openstack"}}, "", "", "OpenStack"},
+	{"nutanix", hivev1.Platform{Nutanix: &nutanix.Platform{}}, "", "", "Nutanix"},
+	{"baremetal", hivev1.Platform{BareMetal: &baremetal.Platform{}}, "", "", "BareMetal"},
+	{"agent-baremetal", hivev1.Platform{AgentBareMetal: &agent.BareMetalPlatform{}}, "", "", "BareMetal"},
+	{"none", hivev1.Platform{None: &none.Platform{}}, "", "", CLOUD_AUTO_DETECT},
+	{"", hivev1.Platform{}, "", "", CLOUD_AUTO_DETECT},
+}
+
+func TestGetPlatformLocation(t *testing.T) {
+
+	for _, tc := range platformTests {
+		assert.Equal(t, tc.name, getPlatformName(tc.platform), "platform name")
+
+		region, datacenter := getPlatformLocation(tc.platform)
+		assert.Equal(t, tc.region, region, tc.name+" region")
+		assert.Equal(t, tc.datacenter, datacenter, tc.name+" datacenter")
+
+		assert.Equal(t, tc.cloud, getCloud(tc.platform), tc.name+" cloud")
+	}
+}
+
+func TestReconcileClusterClaimsRegionForEveryPlatform(t *testing.T) {
+
+	for _, tc := range platformTests {
+		ctx := context.Background()
+
+		ccr := GetClusterClaimsReconciler()
+
+		ccr.Client.Create(ctx, GetClusterClaim(CC_NAMESPACE, CC_NAME, CLUSTER01), &client.CreateOptions{})
+
+		cd := GetClusterDeployment(CLUSTER01, "")
+		cd.Spec.Platform = tc.platform
+		ccr.Client.Create(ctx, cd, &client.CreateOptions{})
+
+		_, err := ccr.Reconcile(ctx, getRequest())
+		assert.Nil(t, err, tc.name+", reconcile was successful")
+
+		var mc mcv1.ManagedCluster
+		err = ccr.Client.Get(ctx, getNamespaceName("", CLUSTER01), &mc)
+		assert.Nil(t, err, tc.name+", managedCluster resource is retrieved")
+
+		assert.Equal(t, tc.cloud, mc.Labels[CLOUD_LABEL], tc.name+" cloud label")
+		assert.Equal(t, VENDOR_OPENSHIFT, mc.Labels[VENDOR_LABEL], tc.name+" vendor label")
+
+		if tc.region == "" {
+			assert.NotContains(t, mc.Labels, REGION_LABEL, tc.name+", no region label without a region")
+		} else {
+			assert.Equal(t, tc.region, mc.Labels[REGION_LABEL], tc.name+" region label")
+		}
+		if tc.datacenter == "" {
+			assert.NotContains(t, mc.Labels, DATACENTER_LABEL, tc.name+", no datacenter label without a datacenter")
+		} else {
+			assert.Equal(t, tc.datacenter, mc.Labels[DATACENTER_LABEL], tc.name+" datacenter label")
+		}
+	}
+}
+
+func TestReconcileClusterClaimsRegionFallback(t *testing.T) {
+
+	ctx := context.Background()
+
+	ccr := GetClusterClaimsReconciler()
+
+	// A region left on the claim does not describe the vSphere cluster
+	cc := GetClusterClaim(CC_NAMESPACE, CC_NAME, CLUSTER01)
+	cc.Labels[REGION_LABEL] = "emea"
+	ccr.Client.Create(ctx, cc, &client.CreateOptions{})
+
+	cd := GetClusterDeployment(CLUSTER01, "")
+	cd.Spec.Platform = hivev1.Platform{VSphere: &vsphere.Platform{Datacenter: "dc-east"}}
+	ccr.Client.Create(ctx, cd, &client.CreateOptions{})
+
+	_, err := ccr.Reconcile(ctx, getRequest())
+	assert.Nil(t, err, "nil, when clusterClaim is found reconcile was successful")
+
+	var mc mcv1.ManagedCluster
+	err = ccr.Client.Get(ctx, getNamespaceName("", CLUSTER01), &mc)
+	assert.Nil(t, err, "nil, when managedCluster resource is retrieved")
+	assert.NotContains(t, mc.Labels, REGION_LABEL, "the claim region is removed when the platform has no region")
+	assert.Equal(t, "dc-east", mc.Labels[DATACENTER_LABEL], "datacenter label")
+}
+
+func TestReconcileClusterClaimsInvalidDatacenter(t *testing.T) {
+
+	ctx := context.Background()
+
+	ccr := GetClusterClaimsReconciler()
+
+	ccr.Client.Create(ctx, GetClusterClaim(CC_NAMESPACE, CC_NAME, CLUSTER01), &client.CreateOptions{})
+
+	// vSphere datacenter names are free-form
+	cd := GetClusterDeployment(CLUSTER01, "")
+	cd.Spec.Platform = hivev1.Platform{VSphere: &vsphere.Platform{Datacenter: "DC 1"}}
+	ccr.Client.Create(ctx, cd, &client.CreateOptions{})
+
+	_, err := ccr.Reconcile(ctx, getRequest())
+	assert.Nil(t, err, "nil, when clusterClaim is found reconcile was successful")
+
+	var mc mcv1.ManagedCluster
+	err = ccr.Client.Get(ctx, getNamespaceName("", CLUSTER01), &mc)
+	assert.Nil(t, err, "nil, the ManagedCluster is created without the invalid label")
+	assert.NotContains(t, mc.Labels, DATACENTER_LABEL, "no datacenter label for an invalid label value")
+}
+
+func TestReconcileClusterClaimsPlatformFromPool(t *testing.T) {
+
+	ctx := context.Background()
+
+	ccr := GetClusterClaimsReconciler()
+
+	cc := GetClusterClaim(CC_NAMESPACE, CC_NAME, CLUSTER01)
+	cp := GetClusterPool(CC_NAMESPACE, cc.Spec.ClusterPoolName, nil)
+	cp.Spec.Platform = hivev1.Platform{GCP: &gcp.Platform{Region: "us-central1"}}
+	ccr.Client.Create(ctx, cp, &client.CreateOptions{})
+	ccr.Client.Create(ctx, cc, &client.CreateOptions{})
+
+	// No ClusterDeployment, the pool platform is used
+	_, err := ccr.Reconcile(ctx, getRequest())
+	assert.Nil(t, err, "nil, when clusterClaim is found reconcile was successful")
+
+	var mc mcv1.ManagedCluster
+	err = ccr.Client.Get(ctx, getNamespaceName("", CLUSTER01), &mc)
+	assert.Nil(t, err, "nil, when managedCluster resource is retrieved")
+	assert.Equal(t, "Google", mc.Labels[CLOUD_LABEL], "label cloud should come from the pool platform")
+	assert.Equal(t, "us-central1", mc.Labels[REGION_LABEL], "label region should come from the pool platform")
+}
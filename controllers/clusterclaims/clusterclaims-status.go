@@ -0,0 +1,138 @@
+// Copyright Contributors to the Open Cluster Management project.
+
+package clusterlcaims
+
+import (
+	"context"
+
+	hivev1 "github.com/openshift/hive/apis/hive/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	mcv1 "open-cluster-management.io/api/cluster/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Conditions maintained by this controller on the ClusterClaim status, next to the ones Hive sets
+const (
+	ManagedClusterCreatedCondition   hivev1.ClusterClaimConditionType = "ManagedClusterCreated"
+	ManagedClusterAcceptedCondition  hivev1.ClusterClaimConditionType = "ManagedClusterAccepted"
+	ManagedClusterJoinedCondition    hivev1.ClusterClaimConditionType = "ManagedClusterJoined"
+	ManagedClusterAvailableCondition hivev1.ClusterClaimConditionType = "ManagedClusterAvailable"
+	RegionDetectedCondition          hivev1.ClusterClaimConditionType = "RegionDetected"
+	ClusterSetAssignedCondition      hivev1.ClusterClaimConditionType = "ClusterSetAssigned"
+)
+
+// syncClaimConditions refreshes the import conditions on the cluster claim from the current
+// state of the claim labels and the ManagedCluster. Only the status is patched, cc is left as is.
+func syncClaimConditions(r *ClusterClaimsReconciler, cc *hivev1.ClusterClaim) error {
+	ctx := context.Background()
+
+	updated := cc.DeepCopy()
+
+	var mc mcv1.ManagedCluster
+	if err := r.Get(ctx, types.NamespacedName{Name: cc.Spec.Namespace}, &mc); err != nil {
+
+		if !k8serrors.IsNotFound(err) {
+			return err
+		}
+		setClaimCondition(updated, ManagedClusterCreatedCondition, corev1.ConditionFalse,
+			"ManagedClusterNotFound", "ManagedCluster "+cc.Spec.Namespace+" does not exist")
+		setClaimCondition(updated, ManagedClusterAcceptedCondition, corev1.ConditionUnknown, "ManagedClusterNotFound", "")
+		setClaimCondition(updated, ManagedClusterJoinedCondition, corev1.ConditionUnknown, "ManagedClusterNotFound", "")
+		setClaimCondition(updated, ManagedClusterAvailableCondition, corev1.ConditionUnknown, "ManagedClusterNotFound", "")
+
+	} else {
+
+		setClaimCondition(updated, ManagedClusterCreatedCondition, corev1.ConditionTrue,
+			"ManagedClusterCreated", "ManagedCluster "+mc.Name+" exists")
+		mirrorManagedClusterCondition(updated, &mc, ManagedClusterAcceptedCondition, mcv1.ManagedClusterConditionHubAccepted)
+		mirrorManagedClusterCondition(updated, &mc, ManagedClusterJoinedCondition, mcv1.ManagedClusterConditionJoined)
+		mirrorManagedClusterCondition(updated, &mc, ManagedClusterAvailableCondition, mcv1.ManagedClusterConditionAvailable)
+	}
+
+	if region := cc.Labels[REGION_LABEL]; region != "" {
+		setClaimCondition(updated, RegionDetectedCondition, corev1.ConditionTrue, "RegionDetected", "Region "+region)
+	} else {
+		setClaimCondition(updated, RegionDetectedCondition, corev1.ConditionFalse, "RegionNotDetected", "")
+	}
+
+	if clusterSet := cc.Labels[ClusterSetLabel]; clusterSet != "" {
+		setClaimCondition(updated, ClusterSetAssignedCondition, corev1.ConditionTrue, "ClusterSetAssigned", "ManagedClusterSet "+clusterSet)
+	} else {
+		setClaimCondition(updated, ClusterSetAssignedCondition, corev1.ConditionFalse, "NoClusterSet", "")
+	}
+
+	if equality.Semantic.DeepEqual(cc.Status, updated.Status) {
+		return nil
+	}
+
+	r.Log.V(DEBUG).Info("Update conditions on cluster claim: " + cc.Name)
+	return r.Status().Patch(ctx, updated, client.MergeFromWithOptions(cc, client.MergeFromWithOptimisticLock{}))
+}
+
+// mirrorManagedClusterCondition copies a ManagedCluster condition to the cluster claim, a condition
+// that has not been reported yet by the registration agent is Unknown
+func mirrorManagedClusterCondition(
+	cc *hivev1.ClusterClaim,
+	mc *mcv1.ManagedCluster,
+	condType hivev1.ClusterClaimConditionType,
+	mcCondType string) {
+
+	mcCond := meta.FindStatusCondition(mc.Status.Conditions, mcCondType)
+	if mcCond == nil {
+		setClaimCondition(cc, condType, corev1.ConditionUnknown, "NotReported", "")
+		return
+	}
+	setClaimCondition(cc, condType, corev1.ConditionStatus(mcCond.Status), mcCond.Reason, mcCond.Message)
+}
+
+// setClaimCondition adds or updates a condition, the transition time only moves when the status changes
+func setClaimCondition(
+	cc *hivev1.ClusterClaim,
+	condType hivev1.ClusterClaimConditionType,
+	status corev1.ConditionStatus,
+	reason string,
+	message string) {
+
+	now := metav1.Now()
+	for i := range cc.Status.Conditions {
+		cond := &cc.Status.Conditions[i]
+		if cond.Type != condType {
+			continue
+		}
+		if cond.Status == status && cond.Reason == reason && cond.Message == message {
+			return
+		}
+		if cond.Status != status {
+			cond.LastTransitionTime = now
+		}
+		cond.Status = status
+		cond.Reason = reason
+		cond.Message = message
+		cond.LastProbeTime = now
+		return
+	}
+
+	cc.Status.Conditions = append(cc.Status.Conditions, hivev1.ClusterClaimCondition{
+		Type:               condType,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		LastProbeTime:      now,
+		LastTransitionTime: now,
+	})
+}
+
+// getClaimCondition returns the condition of the given type, or nil when it is not set
+func getClaimCondition(cc *hivev1.ClusterClaim, condType hivev1.ClusterClaimConditionType) *hivev1.ClusterClaimCondition {
+	for i := range cc.Status.Conditions {
+		if cc.Status.Conditions[i].Type == condType {
+			return &cc.Status.Conditions[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package clusterlcaims
+
+import (
+	"context"
+	"testing"
+
+	hivev1 "github.com/openshift/hive/apis/hive/v1"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	mcv1 "open-cluster-management.io/api/cluster/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestReconcileClusterClaimsConditions(t *testing.T) {
+
+	ctx := context.Background()
+
+	ccr := GetClusterClaimsReconciler()
+
+	ccr.Client.Create(ctx, GetClusterClaim(CC_NAMESPACE, CC_NAME, CLUSTER01), &client.CreateOptions{})
+	ccr.Client.Create(ctx, GetClusterDeployment(CLUSTER01, "aws"), &client.CreateOptions{})
+
+	_, err := ccr.Reconcile(ctx, getRequest())
+	assert.Nil(t, err, "nil, when clusterClaim is found reconcile was successful")
+
+	var cc hivev1.ClusterClaim
+	err = ccr.Client.Get(ctx, getNamespaceName(CC_NAMESPACE, CC_NAME), &cc)
+	assert.Nil(t, err, "nil, when clusterClaim is retrieved")
+
+	cond := getClaimCondition(&cc, ManagedClusterCreatedCondition)
+	assert.NotNil(t, cond, "ManagedClusterCreated condition should be set")
+	assert.Equal(t, corev1.ConditionTrue, cond.Status, "ManagedClusterCreated should be True")
+
+	cond = getClaimCondition(&cc, ManagedClusterJoinedCondition)
+	assert.NotNil(t, cond, "ManagedClusterJoined condition should be set")
+	assert.Equal(t, corev1.ConditionUnknown, cond.Status, "ManagedClusterJoined should be Unknown")
+
+	cond = getClaimCondition(&cc, RegionDetectedCondition)
+	assert.NotNil(t, cond, "RegionDetected condition should be set")
+	assert.Equal(t, corev1.ConditionTrue, cond.Status, "RegionDetected should be True")
+
+	cond = getClaimCondition(&cc, ClusterSetAssignedCondition)
+	assert.NotNil(t, cond, "ClusterSetAssigned condition should be set")
+	assert.Equal(t, corev1.ConditionFalse, cond.Status, "ClusterSetAssigned should be False")
+}
+
+func TestReconcileClusterClaimsConditionsFollowManagedCluster(t *testing.T) {
+
+	ctx := context.Background()
+
+	ccr := GetClusterClaimsReconciler()
+
+	ccr.Client.Create(ctx, GetClusterClaim(CC_NAMESPACE, CC_NAME, CLUSTER01), &client.CreateOptions{})
+
+	_, err := ccr.Reconcile(ctx, getRequest())
+	assert.Nil(t, err, "nil, when clusterClaim is found reconcile was successful")
+
+	var mc mcv1.ManagedCluster
+	err = ccr.Client.Get(ctx, getNamespaceName("", CLUSTER01), &mc)
+	assert.Nil(t, err, "nil, when managedCluster resource is retrieved")
+
+	mc.Status.Conditions = []v1.Condition{
+		{Type: mcv1.ManagedClusterConditionHubAccepted, Status: v1.ConditionTrue, Reason: "HubClusterAdminAccepted"},
+		{Type: mcv1.ManagedClusterConditionJoined, Status: v1.ConditionTrue, Reason: "ManagedClusterJoined"},
+		{Type: mcv1.ManagedClusterConditionAvailable, Status: v1.ConditionFalse, Reason: "ManagedClusterLeaseUpdateStopped"},
+	}
+	err = ccr.Client.Update(ctx, &mc)
+	assert.Nil(t, err, "nil, when managedCluster status is updated")
+
+	// The claim is already imported, conditions must still follow the ManagedCluster
+	_, err = ccr.Reconcile(ctx, getRequest())
+	assert.Nil(t, err, "nil, when clusterClaim is found reconcile was successful")
+
+	var cc hivev1.ClusterClaim
+	err = ccr.Client.Get(ctx, getNamespaceName(CC_NAMESPACE, CC_NAME), &cc)
+	assert.Nil(t, err, "nil, when clusterClaim is retrieved")
+
+	assert.Equal(t, corev1.ConditionTrue, getClaimCondition(&cc, ManagedClusterAcceptedCondition).Status)
+	assert.Equal(t, corev1.ConditionTrue, getClaimCondition(&cc, ManagedClusterJoinedCondition).Status)
+	assert.Equal(t, corev1.ConditionFalse, getClaimCondition(&cc, ManagedClusterAvailableCondition).Status)
+	assert.Equal(t, "ManagedClusterLeaseUpdateStopped", getClaimCondition(&cc, ManagedClusterAvailableCondition).Reason)
+}
+
+func TestSetClaimConditionTransitionTime(t *testing.T) {
+
+	cc := GetClusterClaim(CC_NAMESPACE, CC_NAME, CLUSTER01)
+
+	setClaimCondition(cc, ManagedClusterJoinedCondition, corev1.ConditionFalse, "NotJoined", "")
+	cc.Status.Conditions[0].LastTransitionTime = v1.Time{}
+
+	setClaimCondition(cc, ManagedClusterJoinedCondition, corev1.ConditionFalse, "StillNotJoined", "")
+	assert.True(t, cc.Status.Conditions[0].LastTransitionTime.IsZero(), "transition time should not move without a status change")
+
+	setClaimCondition(cc, ManagedClusterJoinedCondition, corev1.ConditionTrue, "Joined", "")
+	assert.False(t, cc.Status.Conditions[0].LastTransitionTime.IsZero(), "transition time should move on a status change")
+	assert.Len(t, cc.Status.Conditions, 1, "condition should be updated in place")
+}
+
+func TestSyncClaimConditionsStaleClaim(t *testing.T) {
+
+	ctx := context.Background()
+
+	ccr := GetClusterClaimsReconciler()
+
+	ccr.Client.Create(ctx, GetClusterClaim(CC_NAMESPACE, CC_NAME, CLUSTER01), &client.CreateOptions{})
+
+	var stale hivev1.ClusterClaim
+	err := ccr.Client.Get(ctx, getNamespaceName(CC_NAMESPACE, CC_NAME), &stale)
+	assert.Nil(t, err, "nil, when clusterClaim is retrieved")
+
+	// Hive reports a condition after the claim was read
+	var cc hivev1.ClusterClaim
+	ccr.Client.Get(ctx, getNamespaceName(CC_NAMESPACE, CC_NAME), &cc)
+	setClaimCondition(&cc, hivev1.ClusterClaimPendingCondition, corev1.ConditionFalse, "ClusterClaimed", "")
+	err = ccr.Client.Status().Update(ctx, &cc)
+	assert.Nil(t, err, "nil, when clusterClaim status is updated")
+
+	err = syncClaimConditions(ccr, &stale)
+	assert.True(t, k8serrors.IsConflict(err), "conflict, when the conditions are patched on a stale claim")
+
+	err = ccr.Client.Get(ctx, getNamespaceName(CC_NAMESPACE, CC_NAME), &cc)
+	assert.Nil(t, err, "nil, when clusterClaim is retrieved")
+	assert.NotNil(t, getClaimCondition(&cc, hivev1.ClusterClaimPendingCondition), "the Hive condition is kept")
+}
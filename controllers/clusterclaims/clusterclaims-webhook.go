@@ -0,0 +1,184 @@
+// Copyright Contributors to the Open Cluster Management project.
+
+package clusterlcaims
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/go-logr/logr"
+	hivev1 "github.com/openshift/hive/apis/hive/v1"
+	admissionv1 "k8s.io/api/admission/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/types"
+	mcv1beta2 "open-cluster-management.io/api/cluster/v1beta2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// VALIDATE_PATH is where the webhook server serves the ClusterClaim validation
+const VALIDATE_PATH = "/validate-hive-openshift-io-v1-clusterclaim"
+
+// CONTROLLER_PREFIX is the key prefix of the labels and annotations owned by this controller
+const CONTROLLER_PREFIX = "clusterclaims-controller.open-cluster-management.io/"
+
+// ClusterClaimValidator rejects cluster claims that the controller would never import
+type ClusterClaimValidator struct {
+	Client  client.Client
+	Log     logr.Logger
+	Decoder admission.Decoder
+
+	// AuthorizeClusterSet checks that the user may join the ManagedClusterSet labeled on the claim
+	AuthorizeClusterSet bool
+}
+
+func (v *ClusterClaimValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	if req.Operation != admissionv1.Create && req.Operation != admissionv1.Update {
+		return admission.Allowed("")
+	}
+
+	cc := &hivev1.ClusterClaim{}
+	if err := v.Decoder.Decode(req, cc); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	// Never block a claim that is going away, the finalizer must be removable
+	if cc.DeletionTimestamp != nil {
+		return admission.Allowed("")
+	}
+
+	old := &hivev1.ClusterClaim{}
+	if req.Operation == admissionv1.Update {
+		if err := v.Decoder.DecodeRaw(req.OldObject, old); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+	}
+
+	// Only a changed value is checked, so a claim stored before the webhook keeps accepting updates
+	if value, found := cc.Annotations[CREATECM]; found && value != old.Annotations[CREATECM] {
+		if lower := strings.ToLower(value); lower != "true" && lower != "false" {
+			return admission.Denied("annotation " + CREATECM + " must be true or false, not \"" + value + "\"")
+		}
+	}
+
+	for key := range cc.Labels {
+		if _, existed := old.Labels[key]; !existed && strings.HasPrefix(key, CONTROLLER_PREFIX) {
+			return admission.Denied("label " + key + " is reserved for the clusterclaims controller")
+		}
+	}
+
+	if req.Operation == admissionv1.Create || cc.Spec.ClusterPoolName != old.Spec.ClusterPoolName {
+		if denied, err := v.validateClusterPool(ctx, cc); err != nil {
+			return admission.Errored(http.StatusInternalServerError, err)
+		} else if denied != "" {
+			return admission.Denied(denied)
+		}
+	}
+
+	if clusterSet := cc.Labels[ClusterSetLabel]; clusterSet != "" && clusterSet != old.Labels[ClusterSetLabel] {
+		if denied, err := v.validateClusterSet(ctx, req, clusterSet); err != nil {
+			return admission.Errored(http.StatusInternalServerError, err)
+		} else if denied != "" {
+			return admission.Denied(denied)
+		}
+	}
+
+	return admission.Allowed("")
+}
+
+// validateClusterPool returns why the claim is denied when its ClusterPool does not exist
+func (v *ClusterClaimValidator) validateClusterPool(ctx context.Context, cc *hivev1.ClusterClaim) (string, error) {
+	var cp hivev1.ClusterPool
+	err := v.Client.Get(ctx, types.NamespacedName{Namespace: cc.Namespace, Name: cc.Spec.ClusterPoolName}, &cp)
+	if k8serrors.IsNotFound(err) {
+		return "ClusterPool " + cc.Spec.ClusterPoolName + " does not exist in namespace " + cc.Namespace, nil
+	}
+	return "", err
+}
+
+// validateClusterSet returns why the claim is denied when the ManagedClusterSet does not exist, or when
+// AuthorizeClusterSet is set and the user may not join it
+func (v *ClusterClaimValidator) validateClusterSet(ctx context.Context, req admission.Request, clusterSet string) (string, error) {
+	var mcs mcv1beta2.ManagedClusterSet
+	err := v.Client.Get(ctx, types.NamespacedName{Name: clusterSet}, &mcs)
+	if k8serrors.IsNotFound(err) {
+		return "ManagedClusterSet " + clusterSet + " does not exist", nil
+	} else if err != nil && !meta.IsNoMatchError(err) {
+		return "", err
+	}
+
+	if !v.AuthorizeClusterSet {
+		return "", nil
+	}
+
+	allowed, err := reviewClusterSetJoin(ctx, v.Client, req.UserInfo, clusterSet)
+	if err != nil {
+		return "", err
+	}
+	if !allowed {
+		return req.UserInfo.Username + " may not join ManagedClusterSet " + clusterSet, nil
+	}
+	return "", nil
+}
+
+// MUTATE_PATH is where the webhook server serves the ClusterClaim mutation
+const MUTATE_PATH = "/mutate-hive-openshift-io-v1-clusterclaim"
+
+// ClusterClaimMutator records the user that created a cluster claim in the requester annotation, and keeps
+// it from being changed afterwards
+type ClusterClaimMutator struct {
+	Log     logr.Logger
+	Decoder admission.Decoder
+}
+
+func (m *ClusterClaimMutator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	if req.Operation != admissionv1.Create && req.Operation != admissionv1.Update {
+		return admission.Allowed("")
+	}
+
+	cc := &hivev1.ClusterClaim{}
+	if err := m.Decoder.Decode(req, cc); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	requester := ""
+	if req.Operation == admissionv1.Create {
+		value, err := json.Marshal(req.UserInfo)
+		if err != nil {
+			return admission.Errored(http.StatusInternalServerError, err)
+		}
+		requester = string(value)
+	} else {
+		old := &hivev1.ClusterClaim{}
+		if err := m.Decoder.DecodeRaw(req.OldObject, old); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+		requester = old.Annotations[REQUESTER_ANNOTATION]
+	}
+
+	if cc.Annotations[REQUESTER_ANNOTATION] == requester {
+		return admission.Allowed("")
+	}
+
+	if requester == "" {
+		delete(cc.Annotations, REQUESTER_ANNOTATION)
+	} else {
+		if cc.Annotations == nil {
+			cc.Annotations = map[string]string{}
+		}
+		cc.Annotations[REQUESTER_ANNOTATION] = requester
+	}
+
+	if req.Operation == admissionv1.Create {
+		m.Log.V(DEBUG).Info("Record requester: " + req.UserInfo.Username + " on cluster claim: " + cc.Name)
+	}
+
+	mutated, err := json.Marshal(cc)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	return admission.PatchResponseFromRaw(req.Object.Raw, mutated)
+}
@@ -0,0 +1,191 @@
+package clusterlcaims
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	hivev1 "github.com/openshift/hive/apis/hive/v1"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	mcv1 "open-cluster-management.io/api/cluster/v1"
+	mcv1beta2 "open-cluster-management.io/api/cluster/v1beta2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func getClusterClaimValidator(c client.Client) *ClusterClaimValidator {
+	ccr := GetClusterClaimsReconciler()
+	if c == nil {
+		c = ccr.Client
+	}
+	return &ClusterClaimValidator{
+		Client:  c,
+		Log:     ccr.Log,
+		Decoder: admission.NewDecoder(s),
+	}
+}
+
+func getAdmissionRequest(op admissionv1.Operation, cc *hivev1.ClusterClaim, old *hivev1.ClusterClaim) admission.Request {
+	req := admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		Operation: op,
+		UserInfo:  authenticationv1.UserInfo{Username: "alice"},
+	}}
+	raw, _ := json.Marshal(cc)
+	req.Object = runtime.RawExtension{Raw: raw}
+	if old != nil {
+		raw, _ = json.Marshal(old)
+		req.OldObject = runtime.RawExtension{Raw: raw}
+	}
+	return req
+}
+
+func TestValidateClusterClaimClusterPool(t *testing.T) {
+
+	ctx := context.Background()
+
+	v := getClusterClaimValidator(nil)
+
+	cc := GetClusterClaim(CC_NAMESPACE, CC_NAME, NO_CLUSTER)
+	res := v.Handle(ctx, getAdmissionRequest(admissionv1.Create, cc, nil))
+	assert.False(t, res.Allowed, "denied, the ClusterPool does not exist")
+	assert.Contains(t, res.Result.Message, "make-believe", "the pool is named")
+
+	v.Client.Create(ctx, GetClusterPool(CC_NAMESPACE, cc.Spec.ClusterPoolName, nil), &client.CreateOptions{})
+	res = v.Handle(ctx, getAdmissionRequest(admissionv1.Create, cc, nil))
+	assert.True(t, res.Allowed, "allowed, the ClusterPool exists")
+}
+
+func TestValidateClusterClaimUpdate(t *testing.T) {
+
+	ctx := context.Background()
+
+	v := getClusterClaimValidator(nil)
+
+	// The pool is gone, but updates that keep the pool, like the controller's own, are allowed
+	old := GetClusterClaim(CC_NAMESPACE, CC_NAME, CLUSTER01)
+	cc := old.DeepCopy()
+	cc.Annotations = map[string]string{CREATECM: "false"}
+	cc.Finalizers = []string{FINALIZER}
+	res := v.Handle(ctx, getAdmissionRequest(admissionv1.Update, cc, old))
+	assert.True(t, res.Allowed, "allowed, the pool reference did not change")
+
+	cc.Annotations[CREATECM] = "nope"
+	res = v.Handle(ctx, getAdmissionRequest(admissionv1.Update, cc, old))
+	assert.False(t, res.Allowed, "denied, invalid createmanagedcluster annotation")
+
+	// A claim stored with an invalid value still accepts Hive's updates
+	old.Annotations = map[string]string{CREATECM: "no"}
+	cc = old.DeepCopy()
+	cc.Spec.Namespace = CLUSTER01 + "-assigned"
+	res = v.Handle(ctx, getAdmissionRequest(admissionv1.Update, cc, old))
+	assert.True(t, res.Allowed, "allowed, the createmanagedcluster annotation did not change")
+
+	now := v1.NewTime(time.Now())
+	cc.DeletionTimestamp = &now
+	res = v.Handle(ctx, getAdmissionRequest(admissionv1.Update, cc, old))
+	assert.True(t, res.Allowed, "allowed, a deleting claim is never blocked")
+}
+
+func TestValidateClusterClaimLabels(t *testing.T) {
+
+	ctx := context.Background()
+
+	v := getClusterClaimValidator(nil)
+	cc := GetClusterClaim(CC_NAMESPACE, CC_NAME, NO_CLUSTER)
+	v.Client.Create(ctx, GetClusterPool(CC_NAMESPACE, cc.Spec.ClusterPoolName, nil), &client.CreateOptions{})
+
+	cc.Labels[CLAIM_NAME_LABEL] = "someone-else"
+	res := v.Handle(ctx, getAdmissionRequest(admissionv1.Create, cc, nil))
+	assert.False(t, res.Allowed, "denied, the label is reserved for the controller")
+	delete(cc.Labels, CLAIM_NAME_LABEL)
+
+	cc.Labels[ClusterSetLabel] = "missing-set"
+	res = v.Handle(ctx, getAdmissionRequest(admissionv1.Create, cc, nil))
+	assert.False(t, res.Allowed, "denied, the ManagedClusterSet does not exist")
+
+	v.Client.Create(ctx, &mcv1beta2.ManagedClusterSet{ObjectMeta: v1.ObjectMeta{Name: "missing-set"}}, &client.CreateOptions{})
+	res = v.Handle(ctx, getAdmissionRequest(admissionv1.Create, cc, nil))
+	assert.True(t, res.Allowed, "allowed, the ManagedClusterSet exists")
+}
+
+func TestValidateClusterClaimAuthorizeClusterSet(t *testing.T) {
+
+	ctx := context.Background()
+
+	ccr := getAuthorizingReconciler(map[string][]string{"team-set": {"alice"}})
+	v := getClusterClaimValidator(ccr.Client)
+	v.AuthorizeClusterSet = true
+
+	cc := GetClusterClaim(CC_NAMESPACE, CC_NAME, NO_CLUSTER)
+	v.Client.Create(ctx, GetClusterPool(CC_NAMESPACE, cc.Spec.ClusterPoolName, nil), &client.CreateOptions{})
+	v.Client.Create(ctx, &mcv1beta2.ManagedClusterSet{ObjectMeta: v1.ObjectMeta{Name: "team-set"}}, &client.CreateOptions{})
+	v.Client.Create(ctx, &mcv1beta2.ManagedClusterSet{ObjectMeta: v1.ObjectMeta{Name: "admin-set"}}, &client.CreateOptions{})
+
+	cc.Labels[ClusterSetLabel] = "team-set"
+	res := v.Handle(ctx, getAdmissionRequest(admissionv1.Create, cc, nil))
+	assert.True(t, res.Allowed, "allowed, alice may join team-set")
+
+	cc.Labels[ClusterSetLabel] = "admin-set"
+	res = v.Handle(ctx, getAdmissionRequest(admissionv1.Create, cc, nil))
+	assert.False(t, res.Allowed, "denied, alice may not join admin-set")
+}
+
+func TestMutateClusterClaimRecordsRequester(t *testing.T) {
+
+	ctx := context.Background()
+
+	m := &ClusterClaimMutator{Log: GetClusterClaimsReconciler().Log, Decoder: admission.NewDecoder(s)}
+
+	// A forged requester is replaced on create
+	cc := GetClusterClaim(CC_NAMESPACE, CC_NAME, NO_CLUSTER)
+	cc.Annotations = map[string]string{REQUESTER_ANNOTATION: `{"username":"admin"}`}
+	req := getAdmissionRequest(admissionv1.Create, cc, nil)
+	req.UserInfo.Groups = []string{"dev-team", "system:authenticated"}
+
+	res := m.Handle(ctx, req)
+	assert.True(t, res.Allowed, "claims are never denied")
+	if assert.Len(t, res.Patches, 1, "the requester annotation is patched") {
+		var requester authenticationv1.UserInfo
+		err := json.Unmarshal([]byte(res.Patches[0].Value.(string)), &requester)
+		assert.Nil(t, err, "nil, the requester is JSON")
+		assert.Equal(t, "alice", requester.Username, "the requesting user")
+		assert.Equal(t, []string{"dev-team", "system:authenticated"}, requester.Groups, "the requesting groups")
+	}
+
+	// The recorded requester cannot be changed
+	old := GetClusterClaim(CC_NAMESPACE, CC_NAME, NO_CLUSTER)
+	old.Annotations = map[string]string{REQUESTER_ANNOTATION: `{"username":"alice"}`}
+	cc.Annotations[REQUESTER_ANNOTATION] = `{"username":"admin"}`
+	res = m.Handle(ctx, getAdmissionRequest(admissionv1.Update, cc, old))
+	assert.True(t, res.Allowed, "claims are never denied")
+	if assert.Len(t, res.Patches, 1, "the requester annotation is restored") {
+		assert.Equal(t, `{"username":"alice"}`, res.Patches[0].Value, "the original requester")
+	}
+
+	res = m.Handle(ctx, getAdmissionRequest(admissionv1.Update, old, old))
+	assert.Empty(t, res.Patches, "nothing to patch when the requester is kept")
+}
+
+func TestReconcileClusterClaimsCopiesRequester(t *testing.T) {
+
+	ctx := context.Background()
+
+	ccr := GetClusterClaimsReconciler()
+
+	cc := GetClusterClaim(CC_NAMESPACE, CC_NAME, CLUSTER01)
+	cc.Annotations = map[string]string{REQUESTER_ANNOTATION: `{"username":"alice","groups":["dev-team"]}`}
+	ccr.Client.Create(ctx, cc, &client.CreateOptions{})
+
+	_, err := ccr.Reconcile(ctx, getRequest())
+	assert.Nil(t, err, "nil, when clusterClaim is found reconcile was successful")
+
+	var mc mcv1.ManagedCluster
+	err = ccr.Client.Get(ctx, getNamespaceName("", CLUSTER01), &mc)
+	assert.Nil(t, err, "nil, when managedCluster resource is retrieved")
+	assert.Equal(t, `{"username":"alice","groups":["dev-team"]}`, mc.Annotations[REQUESTER_ANNOTATION], "the requester is copied")
+}
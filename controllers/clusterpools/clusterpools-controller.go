@@ -5,11 +5,13 @@ package clusterpools
 import (
 	"context"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/go-logr/logr"
 	hivev1 "github.com/openshift/hive/apis/hive/v1"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
-	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	"k8s.io/apimachinery/pkg/runtime"
@@ -28,6 +30,9 @@ const WARN = -1
 const ERROR = -2
 const FINALIZER = "clusterpools-controller.open-cluster-management.io/cleanup"
 
+// DEPROVISION_REQUEUE is how often a deleted pool checks on its clusters being deprovisioned
+const DEPROVISION_REQUEUE = 1 * time.Minute
+
 const LABEL_NAMESPACE = "open-cluster-management.io/managed-by"
 const CLUSTERPOOLS = "clusterpools"
 
@@ -37,6 +42,9 @@ type ClusterPoolsReconciler struct {
 	client.Client
 	Log    logr.Logger
 	Scheme *runtime.Scheme
+
+	// DeleteLabeledSecretsOnly only deletes the secrets labeled as managed by clusterpools
+	DeleteLabeledSecretsOnly bool
 }
 
 func (r *ClusterPoolsReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
@@ -59,6 +67,17 @@ func (r *ClusterPoolsReconciler) Reconcile(ctx context.Context, req ctrl.Request
 	log.V(INFO).Info("Reconcile cluster pool: " + target)
 
 	if cp.DeletionTimestamp != nil {
+		// Hive needs the pool credentials to deprovision the pool's clusters
+		pending, err := getPoolClusterDeployments(r, &cp)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if len(pending) > 0 {
+			log.V(INFO).Info(fmt.Sprintf("Waiting for %d cluster deployments to be deprovisioned, including: %s",
+				len(pending), pending[0]))
+			return ctrl.Result{RequeueAfter: DEPROVISION_REQUEUE}, nil
+		}
+
 		if err := deleteResources(r, &cp); err != nil {
 			return ctrl.Result{}, err
 		}
@@ -112,108 +131,206 @@ func removeFinalizer(r *ClusterPoolsReconciler, cc *hivev1.ClusterPool) error {
 	return err
 
 }
-func getCPDetails(cp hivev1.ClusterPool) (cpType string, providerSecretName string) {
-	if cp.Spec.Platform.AWS != nil {
-		return "aws", cp.Spec.Platform.AWS.CredentialsSecretRef.Name
-	} else if cp.Spec.Platform.GCP != nil {
-		return "gcp", cp.Spec.Platform.GCP.CredentialsSecretRef.Name
-	} else if cp.Spec.Platform.Azure != nil {
-		return "azure", cp.Spec.Platform.Azure.CredentialsSecretRef.Name
-	}
-	return "skip", ""
-}
-func deleteResources(r *ClusterPoolsReconciler, cp *hivev1.ClusterPool) error {
-	ctx := context.Background()
-	log := r.Log
 
-	var cps hivev1.ClusterPoolList
-	if err := r.List(ctx, &cps, &client.ListOptions{Namespace: cp.Namespace}); err != nil {
+// getPoolClusterDeployments returns the namespace/name of the unclaimed cluster deployments of the pool that still
+// exist, claimed clusters are detached from the pool and are not waited for
+func getPoolClusterDeployments(r *ClusterPoolsReconciler, cp *hivev1.ClusterPool) ([]string, error) {
+	var cds hivev1.ClusterDeploymentList
+	if err := r.List(context.Background(), &cds); err != nil {
+		return nil, err
+	}
 
-		if k8serrors.IsNotFound(err) {
-			log.V(INFO).Info("No Cluster Pools found")
-			return nil
-		} else {
-			return err
+	pending := []string{}
+	for _, cd := range cds.Items {
+		ref := cd.Spec.ClusterPoolRef
+		if ref == nil || ref.Namespace != cp.Namespace || ref.PoolName != cp.Name || ref.ClaimName != "" {
+			continue
 		}
+		pending = append(pending, cd.Namespace+"/"+cd.Name)
+	}
+	return pending, nil
+}
 
-	} else {
-
-		// Remove secrets that are not used by any other cluster pool in the namespace
-		foundPullSecret := false
-		foundInstallConfigSecret := false
-		foundProviderSecret := false
+// getCPDetails returns the platform of the pool, and the names of its platform secrets: the provider credential
+// and, on vSphere, OpenStack and Nutanix, the CA certificates
+func getCPDetails(cp hivev1.ClusterPool) (cpType string, providerSecretNames []string) {
+	return getPlatformDetails(cp.Spec.Platform)
+}
 
-		cpType, providerSecretName := getCPDetails(*cp)
+// getPlatformDetails returns the name of the platform and the names of its secrets
+func getPlatformDetails(platform hivev1.Platform) (string, []string) {
+	switch {
+	case platform.AWS != nil:
+		return "aws", secretNames(platform.AWS.CredentialsSecretRef)
+	case platform.GCP != nil:
+		return "gcp", secretNames(platform.GCP.CredentialsSecretRef)
+	case platform.Azure != nil:
+		return "azure", secretNames(platform.Azure.CredentialsSecretRef)
+	case platform.IBMCloud != nil:
+		return "ibmcloud", secretNames(platform.IBMCloud.CredentialsSecretRef)
+	case platform.VSphere != nil:
+		return "vsphere", secretNames(platform.VSphere.CredentialsSecretRef, platform.VSphere.CertificatesSecretRef)
+	case platform.OpenStack != nil:
+		refs := []corev1.LocalObjectReference{platform.OpenStack.CredentialsSecretRef}
+		if platform.OpenStack.CertificatesSecretRef != nil {
+			refs = append(refs, *platform.OpenStack.CertificatesSecretRef)
+		}
+		return "openstack", secretNames(refs...)
+	case platform.Nutanix != nil:
+		return "nutanix", secretNames(platform.Nutanix.CredentialsSecretRef, platform.Nutanix.CertificatesSecretRef)
+	case platform.BareMetal != nil:
+		return "baremetal", secretNames(platform.BareMetal.LibvirtSSHPrivateKeySecretRef)
+	}
+	return "skip", []string{}
+}
 
-		for _, foundCp := range cps.Items {
+// secretNames returns the names of the secret references that are set
+func secretNames(refs ...corev1.LocalObjectReference) []string {
+	names := []string{}
+	for _, ref := range refs {
+		if ref.Name != "" {
+			names = append(names, ref.Name)
+		}
+	}
+	return names
+}
 
-			// Skip if the cluster pool being deleted is the element in the list
-			if cp.Name == foundCp.Name {
-				continue
-			}
+// deleteResources removes the secrets of the cluster pool that no other object in the namespace references, then
+// the namespace itself when the pool was the last one in it
+func deleteResources(r *ClusterPoolsReconciler, cp *hivev1.ClusterPool) error {
+	log := r.Log
 
-			if cp.Spec.PullSecretRef != nil && foundCp.Spec.PullSecretRef != nil && cp.Spec.PullSecretRef.Name == foundCp.Spec.PullSecretRef.Name {
-				foundPullSecret = true
-			}
+	if strings.ToLower(cp.Annotations[RETAIN_SECRETS]) == "true" {
+		log.V(INFO).Info("Cluster pool: " + cp.Name + " retains its secrets and namespace")
+		return nil
+	}
 
-			if cp.Spec.InstallConfigSecretTemplateRef != nil && foundCp.Spec.InstallConfigSecretTemplateRef != nil && cp.Spec.InstallConfigSecretTemplateRef.Name == foundCp.Spec.InstallConfigSecretTemplateRef.Name {
-				foundInstallConfigSecret = true
-			}
+	refs, err := getSecretReferences(r, cp)
+	if err != nil {
+		return err
+	}
 
-			// This needs to happen after the cp.Name == foundCp.Name check
+	cpType, providerSecretNames := getCPDetails(*cp)
+	log.V(DEBUG).Info(fmt.Sprintf("platform: %v, providerSecretNames: %v", cpType, providerSecretNames))
 
-			foundCpType, foundProviderSecretName := getCPDetails(foundCp)
+	for _, name := range getPoolSecretNames(*cp) {
 
-			if cpType == foundCpType && providerSecretName == foundProviderSecretName {
-				foundProviderSecret = true
-			}
+		if referrers := refs[name]; len(referrers) > 0 {
+			log.V(INFO).Info("Shared secret: " + name + " is kept, it is referenced by: " + strings.Join(referrers, ", "))
+			continue
 		}
 
-		log.V(INFO).Info(
-			fmt.Sprintf("Shared secrets found, install-config: %v, Pull secret: %v, Provider credential: %v",
-				foundInstallConfigSecret, foundPullSecret, foundProviderSecret))
+		if err := deleteSecret(r, cp.Namespace, name); err != nil {
+			return err
+		}
+	}
 
-		log.V(DEBUG).Info(fmt.Sprintf("providerSecretName: %v", providerSecretName))
+	return deleteNamespace(r, cp)
+}
 
-		if !foundInstallConfigSecret && cp.Spec.InstallConfigSecretTemplateRef != nil {
+// deleteNamespace removes the namespace of the last cluster pool, when it is labeled as managed by clusterpools.
+// The namespace is kept while it holds another cluster pool, a cluster claim or a cluster deployment, claimed
+// or not, as deleting the namespace would deprovision the cluster, or a protected secret. When only labeled secrets
+// are deleted, the namespace is also kept while it holds a secret without the label.
+func deleteNamespace(r *ClusterPoolsReconciler, cp *hivev1.ClusterPool) error {
+	ctx := context.Background()
+	log := r.Log
 
-			if err := deleteSecret(r, cp.Namespace, cp.Spec.InstallConfigSecretTemplateRef.Name); err != nil {
-				return err
-			}
-			log.V(INFO).Info("Deleted install-config secret: " + cp.Spec.InstallConfigSecretTemplateRef.Name)
+	ns, err := r.KubeClient.CoreV1().Namespaces().Get(ctx, cp.Namespace, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
 		}
+		return err
+	}
 
-		if !foundPullSecret && cp.Spec.PullSecretRef != nil {
+	if ns.Labels[LABEL_NAMESPACE] != CLUSTERPOOLS || ns.DeletionTimestamp != nil {
+		return nil
+	}
 
-			if err := deleteSecret(r, cp.Namespace, cp.Spec.PullSecretRef.Name); err != nil {
-				return err
-			}
-			log.V(INFO).Info("Deleted Pull-Secret secret: " + cp.Spec.PullSecretRef.Name)
+	var cps hivev1.ClusterPoolList
+	if err := r.List(ctx, &cps, &client.ListOptions{Namespace: cp.Namespace}); err != nil {
+		return err
+	}
+	for _, foundCp := range cps.Items {
+		if foundCp.Name != cp.Name {
+			log.V(INFO).Info("Keep namespace: " + ns.Name + ", it has cluster pool: " + foundCp.Name)
+			return nil
 		}
+	}
+
+	var ccs hivev1.ClusterClaimList
+	if err := r.List(ctx, &ccs, &client.ListOptions{Namespace: cp.Namespace}); err != nil {
+		return err
+	}
+	if len(ccs.Items) > 0 {
+		log.V(INFO).Info("Keep namespace: " + ns.Name + ", it has cluster claim: " + ccs.Items[0].Name)
+		return nil
+	}
 
-		if !foundProviderSecret && providerSecretName != "" {
+	var cds hivev1.ClusterDeploymentList
+	if err := r.List(ctx, &cds, &client.ListOptions{Namespace: cp.Namespace}); err != nil {
+		return err
+	}
+	if len(cds.Items) > 0 {
+		log.V(INFO).Info("Keep namespace: " + ns.Name + ", it has cluster deployment: " + cds.Items[0].Name)
+		return nil
+	}
 
-			if err := deleteSecret(r, cp.Namespace, providerSecretName); err != nil {
-				return err
-			}
-			log.V(INFO).Info("Deleted Provider-Credential secret: " + providerSecretName)
+	secrets, err := r.KubeClient.CoreV1().Secrets(cp.Namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	for _, secret := range secrets.Items {
+		if strings.ToLower(secret.Annotations[PROTECT_SECRET]) == "true" {
+			log.V(INFO).Info("Keep namespace: " + ns.Name + ", it has protected secret: " + secret.Name)
+			return nil
+		}
+		// Service account secrets are generated in every namespace, they are not created by hand
+		_, serviceAccount := secret.Annotations[corev1.ServiceAccountNameKey]
+		if r.DeleteLabeledSecretsOnly && !serviceAccount && secret.Labels[LABEL_NAMESPACE] != CLUSTERPOOLS {
+			log.V(INFO).Info("Keep namespace: " + ns.Name + ", it has secret: " + secret.Name + " not labeled " +
+				LABEL_NAMESPACE + "=" + CLUSTERPOOLS)
+			return nil
 		}
 	}
 
+	if err := r.KubeClient.CoreV1().Namespaces().Delete(ctx, ns.Name, metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	log.V(INFO).Info("Deleted namespace: " + ns.Name)
 	return nil
 }
 
+// deleteSecret removes the secret, unless it is protected, or not labeled as managed by clusterpools when
+// only those secrets are deleted
 func deleteSecret(r *ClusterPoolsReconciler, namespace string, name string) error {
 	ctx := context.Background()
+	log := r.Log
+
 	// Keep going if the secret is not found, but if found, remove it
-	_, err := r.KubeClient.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	secret, err := r.KubeClient.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
 	if err != nil {
 		if errors.IsNotFound(err) {
-			r.Log.V(WARN).Info("Secret: " + name + " was not found")
+			log.V(WARN).Info("Secret: " + name + " was not found")
 			return nil
 		}
 		return err
 	}
 
-	return r.KubeClient.CoreV1().Secrets(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+	if strings.ToLower(secret.Annotations[PROTECT_SECRET]) == "true" {
+		log.V(INFO).Info("Protected secret: " + name + " is kept")
+		return nil
+	}
+
+	if r.DeleteLabeledSecretsOnly && secret.Labels[LABEL_NAMESPACE] != CLUSTERPOOLS {
+		log.V(INFO).Info("Secret: " + name + " is kept, it is not labeled " + LABEL_NAMESPACE + "=" + CLUSTERPOOLS)
+		return nil
+	}
+
+	if err := r.KubeClient.CoreV1().Secrets(namespace).Delete(ctx, name, metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	log.V(INFO).Info("Deleted secret: " + name)
+	return nil
 }
@@ -11,7 +11,12 @@ import (
 	hivev1 "github.com/openshift/hive/apis/hive/v1"
 	"github.com/openshift/hive/apis/hive/v1/aws"
 	"github.com/openshift/hive/apis/hive/v1/azure"
+	"github.com/openshift/hive/apis/hive/v1/baremetal"
 	"github.com/openshift/hive/apis/hive/v1/gcp"
+	"github.com/openshift/hive/apis/hive/v1/ibmcloud"
+	"github.com/openshift/hive/apis/hive/v1/nutanix"
+	"github.com/openshift/hive/apis/hive/v1/openstack"
+	"github.com/openshift/hive/apis/hive/v1/vsphere"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/zap/zapcore"
 	corev1 "k8s.io/api/core/v1"
@@ -100,6 +105,25 @@ func GetClusterPool(namespace string, name string, poolType string) *hivev1.Clus
 		cp.Spec.Platform.GCP = &gcp.Platform{CredentialsSecretRef: corev1.LocalObjectReference{Name: "secret03"}}
 	case "azure":
 		cp.Spec.Platform.Azure = &azure.Platform{CredentialsSecretRef: corev1.LocalObjectReference{Name: "secret03"}}
+	case "ibmcloud":
+		cp.Spec.Platform.IBMCloud = &ibmcloud.Platform{CredentialsSecretRef: corev1.LocalObjectReference{Name: "secret03"}}
+	case "vsphere":
+		cp.Spec.Platform.VSphere = &vsphere.Platform{
+			CredentialsSecretRef:  corev1.LocalObjectReference{Name: "secret03"},
+			CertificatesSecretRef: corev1.LocalObjectReference{Name: "secret04"},
+		}
+	case "openstack":
+		cp.Spec.Platform.OpenStack = &openstack.Platform{
+			CredentialsSecretRef:  corev1.LocalObjectReference{Name: "secret03"},
+			CertificatesSecretRef: &corev1.LocalObjectReference{Name: "secret04"},
+		}
+	case "nutanix":
+		cp.Spec.Platform.Nutanix = &nutanix.Platform{
+			CredentialsSecretRef:  corev1.LocalObjectReference{Name: "secret03"},
+			CertificatesSecretRef: corev1.LocalObjectReference{Name: "secret04"},
+		}
+	case "baremetal":
+		cp.Spec.Platform.BareMetal = &baremetal.Platform{LibvirtSSHPrivateKeySecretRef: corev1.LocalObjectReference{Name: "secret03"}}
 	default:
 		panic(errors.New("GetClusterPool: Invalid poolType: " + poolType))
 	}
@@ -459,3 +483,254 @@ func TestReconcileClusterPoolDeleteWithFinalizer(t *testing.T) {
 
 	assert.Nil(t, err, "nil, when clusterPool delete reconcile successful")
 }
+
+func getNamespace(name string, labels map[string]string) *corev1.Namespace {
+	return &corev1.Namespace{
+		ObjectMeta: v1.ObjectMeta{
+			Name:   name,
+			Labels: labels,
+		},
+	}
+}
+
+func TestReconcileClusterPoolDeleteNamespace(t *testing.T) {
+
+	ctx := context.Background()
+
+	cpr := GetClusterPoolsReconciler()
+
+	// The finalizer holds the deleted pool
+	cp := GetClusterPool(CP_NAMESPACE, CP_NAME, "aws")
+	cp.Finalizers = []string{FINALIZER}
+	err := cpr.Client.Create(ctx, cp, &client.CreateOptions{})
+	assert.Nil(t, err, "nil, when clusterPool is created")
+	err = cpr.Client.Delete(ctx, cp)
+	assert.Nil(t, err, "nil, when clusterPool is deleted")
+
+	cpr.KubeClient.CoreV1().Namespaces().Create(ctx,
+		getNamespace(CP_NAMESPACE, map[string]string{LABEL_NAMESPACE: CLUSTERPOOLS}), v1.CreateOptions{})
+
+	_, err = cpr.Reconcile(ctx, getRequest())
+	assert.Nil(t, err, "nil, when clusterPool delete reconcile successful")
+
+	_, err = cpr.KubeClient.CoreV1().Namespaces().Get(ctx, CP_NAMESPACE, v1.GetOptions{})
+	assert.NotNil(t, err, "not nil, when namespace was deleted with the last pool")
+	assert.Contains(t, err.Error(), " not found", "namespace should not be found")
+}
+
+func TestReconcileClusterPoolKeepUnlabeledNamespace(t *testing.T) {
+
+	ctx := context.Background()
+
+	cpr := GetClusterPoolsReconciler()
+
+	cp := GetClusterPool(CP_NAMESPACE, CP_NAME, "aws")
+	cp.DeletionTimestamp = &v1.Time{Time: time.Now()}
+
+	cpr.KubeClient.CoreV1().Namespaces().Create(ctx, getNamespace(CP_NAMESPACE, nil), v1.CreateOptions{})
+
+	err := deleteResources(cpr, cp)
+	assert.Nil(t, err, "nil, when clusterPool resources are deleted")
+
+	_, err = cpr.KubeClient.CoreV1().Namespaces().Get(ctx, CP_NAMESPACE, v1.GetOptions{})
+	assert.Nil(t, err, "nil, the namespace is not managed by clusterpools")
+}
+
+func TestReconcileClusterPoolKeepSharedNamespace(t *testing.T) {
+
+	ctx := context.Background()
+
+	claim := &hivev1.ClusterClaim{
+		ObjectMeta: v1.ObjectMeta{Name: "my-claim", Namespace: CP_NAMESPACE},
+		Spec:       hivev1.ClusterClaimSpec{ClusterPoolName: CP_NAME},
+	}
+	unclaimed := &hivev1.ClusterDeployment{
+		ObjectMeta: v1.ObjectMeta{Name: CLUSTER01, Namespace: CP_NAMESPACE},
+		Spec: hivev1.ClusterDeploymentSpec{
+			ClusterPoolRef: &hivev1.ClusterPoolReference{Namespace: CP_NAMESPACE, PoolName: CP_NAME},
+		},
+	}
+	deleting := GetClusterPool(CP_NAMESPACE, CP_NAME+"03", "aws")
+	deleting.Finalizers = []string{FINALIZER}
+
+	sharedTests := []struct {
+		name      string
+		resources []client.Object
+		kept      bool
+	}{
+		{"another pool", []client.Object{GetClusterPool(CP_NAMESPACE, CP_NAME+"02", "aws")}, true},
+		{"a cluster claim", []client.Object{claim.DeepCopy()}, true},
+		{"an unclaimed cluster deployment", []client.Object{unclaimed.DeepCopy()}, true},
+		{"another pool being deleted", []client.Object{deleting.DeepCopy()}, true},
+	}
+
+	for _, tc := range sharedTests {
+		cpr := GetClusterPoolsReconciler()
+
+		cp := GetClusterPool(CP_NAMESPACE, CP_NAME, "aws")
+		cp.DeletionTimestamp = &v1.Time{Time: time.Now()}
+
+		for _, obj := range tc.resources {
+			err := cpr.Client.Create(ctx, obj, &client.CreateOptions{})
+			assert.Nil(t, err, tc.name+", created")
+			if obj.GetName() == deleting.Name {
+				err = cpr.Client.Delete(ctx, obj)
+				assert.Nil(t, err, tc.name+", deleted")
+			}
+		}
+
+		cpr.KubeClient.CoreV1().Namespaces().Create(ctx,
+			getNamespace(CP_NAMESPACE, map[string]string{LABEL_NAMESPACE: CLUSTERPOOLS}), v1.CreateOptions{})
+
+		err := deleteResources(cpr, cp)
+		assert.Nil(t, err, tc.name+", clusterPool resources are deleted")
+
+		_, err = cpr.KubeClient.CoreV1().Namespaces().Get(ctx, CP_NAMESPACE, v1.GetOptions{})
+		if tc.kept {
+			assert.Nil(t, err, tc.name+", the namespace is kept")
+		} else {
+			assert.NotNil(t, err, tc.name+", the namespace is deleted")
+		}
+	}
+}
+
+func TestReconcileClusterPoolWaitForDeprovision(t *testing.T) {
+
+	ctx := context.Background()
+
+	cpr := GetClusterPoolsReconciler()
+
+	cp := GetClusterPool(CP_NAMESPACE, CP_NAME, "aws")
+	cp.Finalizers = []string{FINALIZER}
+	err := cpr.Client.Create(ctx, cp, &client.CreateOptions{})
+	assert.Nil(t, err, "nil, when clusterPool is created")
+	err = cpr.Client.Delete(ctx, cp)
+	assert.Nil(t, err, "nil, when clusterPool is deleted")
+
+	cpr.KubeClient.CoreV1().Secrets(CP_NAMESPACE).Create(ctx, getSecret(CP_NAMESPACE, "secret03"), v1.CreateOptions{})
+
+	// Hive is deprovisioning an unclaimed cluster of the pool
+	cd := &hivev1.ClusterDeployment{
+		ObjectMeta: v1.ObjectMeta{Name: CLUSTER01, Namespace: CLUSTER01},
+		Spec: hivev1.ClusterDeploymentSpec{
+			ClusterPoolRef: &hivev1.ClusterPoolReference{Namespace: CP_NAMESPACE, PoolName: CP_NAME},
+		},
+	}
+	cpr.Client.Create(ctx, cd, &client.CreateOptions{})
+
+	// A claimed cluster is detached from the pool
+	claimed := &hivev1.ClusterDeployment{
+		ObjectMeta: v1.ObjectMeta{Name: "cluster02", Namespace: "cluster02"},
+		Spec: hivev1.ClusterDeploymentSpec{
+			ClusterPoolRef: &hivev1.ClusterPoolReference{Namespace: CP_NAMESPACE, PoolName: CP_NAME, ClaimName: "my-claim"},
+		},
+	}
+	cpr.Client.Create(ctx, claimed, &client.CreateOptions{})
+
+	res, err := cpr.Reconcile(ctx, getRequest())
+	assert.Nil(t, err, "nil, when clusterPool delete reconcile successful")
+	assert.Equal(t, DEPROVISION_REQUEUE, res.RequeueAfter, "requeue while the cluster is deprovisioned")
+
+	_, err = cpr.KubeClient.CoreV1().Secrets(CP_NAMESPACE).Get(ctx, "secret03", v1.GetOptions{})
+	assert.Nil(t, err, "nil, the provider credential is kept for the deprovision")
+
+	err = cpr.Client.Get(ctx, getNamespaceName(CP_NAMESPACE, CP_NAME), cp)
+	assert.Nil(t, err, "nil, the finalizer holds the clusterPool")
+
+	// The deprovision is done
+	err = cpr.Client.Delete(ctx, cd)
+	assert.Nil(t, err, "nil, when clusterDeployment is deleted")
+
+	res, err = cpr.Reconcile(ctx, getRequest())
+	assert.Nil(t, err, "nil, when clusterPool delete reconcile successful")
+	assert.Zero(t, res.RequeueAfter, "no requeue once the clusters are deprovisioned")
+
+	_, err = cpr.KubeClient.CoreV1().Secrets(CP_NAMESPACE).Get(ctx, "secret03", v1.GetOptions{})
+	assert.NotNil(t, err, "not nil, when secret was successfully deleted")
+	assert.Contains(t, err.Error(), " not found", "secret should not be found")
+}
+
+var platformSecretTests = []struct {
+	poolType string
+	secrets  []string
+}{
+	{"aws", []string{"secret03"}},
+	{"gcp", []string{"secret03"}},
+	{"azure", []string{"secret03"}},
+	{"ibmcloud", []string{"secret03"}},
+	{"vsphere", []string{"secret03", "secret04"}},
+	{"openstack", []string{"secret03", "secret04"}},
+	{"nutanix", []string{"secret03", "secret04"}},
+	{"baremetal", []string{"secret03"}},
+}
+
+func TestGetCPDetails(t *testing.T) {
+
+	for _, tc := range platformSecretTests {
+		cpType, secrets := getCPDetails(*GetClusterPool(CP_NAMESPACE, CP_NAME, tc.poolType))
+		assert.Equal(t, tc.poolType, cpType, "platform")
+		assert.Equal(t, tc.secrets, secrets, tc.poolType+" platform secrets")
+	}
+
+	cpType, secrets := getCPDetails(*GetClusterPoolNoRefs(CP_NAMESPACE, CP_NAME, ""))
+	assert.Equal(t, "skip", cpType, "no platform")
+	assert.Empty(t, secrets, "no platform secrets")
+
+	// OpenStack certificates are optional
+	cp := GetClusterPool(CP_NAMESPACE, CP_NAME, "openstack")
+	cp.Spec.Platform.OpenStack.CertificatesSecretRef = nil
+	_, secrets = getCPDetails(*cp)
+	assert.Equal(t, []string{"secret03"}, secrets, "openstack without certificates")
+}
+
+func TestReconcileClusterPoolDeletePlatformSecrets(t *testing.T) {
+
+	ctx := context.Background()
+
+	for _, tc := range platformSecretTests {
+		cpr := GetClusterPoolsReconciler()
+
+		cp := GetClusterPool(CP_NAMESPACE, CP_NAME, tc.poolType)
+		for _, name := range []string{"secret01", "secret02", "secret03", "secret04"} {
+			cpr.KubeClient.CoreV1().Secrets(CP_NAMESPACE).Create(ctx, getSecret(CP_NAMESPACE, name), v1.CreateOptions{})
+		}
+
+		err := deleteResources(cpr, cp)
+		assert.Nil(t, err, tc.poolType+", clusterPool resources are deleted")
+
+		for _, name := range tc.secrets {
+			_, err = cpr.KubeClient.CoreV1().Secrets(CP_NAMESPACE).Get(ctx, name, v1.GetOptions{})
+			assert.NotNil(t, err, tc.poolType+", secret "+name+" is deleted")
+		}
+		if len(tc.secrets) == 1 {
+			_, err = cpr.KubeClient.CoreV1().Secrets(CP_NAMESPACE).Get(ctx, "secret04", v1.GetOptions{})
+			assert.Nil(t, err, tc.poolType+", unreferenced secret is kept")
+		}
+	}
+}
+
+func TestReconcileClusterPoolDeleteSharedCertificates(t *testing.T) {
+
+	ctx := context.Background()
+
+	cpr := GetClusterPoolsReconciler()
+
+	// Another pool, on OpenStack, uses the same CA certificates
+	cp := GetClusterPool(CP_NAMESPACE, CP_NAME, "vsphere")
+	other := GetClusterPool(CP_NAMESPACE, CP_NAME+"02", "openstack")
+	other.Spec.Platform.OpenStack.CredentialsSecretRef.Name = "secret05"
+	cpr.Client.Create(ctx, other, &client.CreateOptions{})
+
+	for _, name := range []string{"secret03", "secret04"} {
+		cpr.KubeClient.CoreV1().Secrets(CP_NAMESPACE).Create(ctx, getSecret(CP_NAMESPACE, name), v1.CreateOptions{})
+	}
+
+	err := deleteResources(cpr, cp)
+	assert.Nil(t, err, "nil, when clusterPool resources are deleted")
+
+	_, err = cpr.KubeClient.CoreV1().Secrets(CP_NAMESPACE).Get(ctx, "secret03", v1.GetOptions{})
+	assert.NotNil(t, err, "not nil, the vSphere credential is deleted")
+
+	_, err = cpr.KubeClient.CoreV1().Secrets(CP_NAMESPACE).Get(ctx, "secret04", v1.GetOptions{})
+	assert.Nil(t, err, "nil, the shared certificates are kept")
+}
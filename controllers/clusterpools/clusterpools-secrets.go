@@ -0,0 +1,166 @@
+// Copyright Contributors to the Open Cluster Management project.
+
+package clusterpools
+
+import (
+	"context"
+
+	hivev1 "github.com/openshift/hive/apis/hive/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// PROTECT_SECRET set to "true" on a secret keeps it, and its namespace, when a cluster pool using it is deleted
+const PROTECT_SECRET = "cluster.open-cluster-management.io/protect-secret"
+
+// RETAIN_SECRETS set to "true" on a cluster pool keeps all of its secrets, and its namespace, when it is deleted
+const RETAIN_SECRETS = "cluster.open-cluster-management.io/retain-secrets"
+
+// secretReferences indexes the secrets of a namespace by name, with the objects that reference them
+type secretReferences map[string][]string
+
+// add records that the referrer uses the named secrets
+func (refs secretReferences) add(referrer string, names ...string) {
+	for _, name := range names {
+		refs[name] = append(refs[name], referrer)
+	}
+}
+
+// getPoolSecretNames returns, without duplicates, every secret of its namespace the cluster pool references
+func getPoolSecretNames(cp hivev1.ClusterPool) []string {
+	names := []string{}
+	if cp.Spec.PullSecretRef != nil {
+		names = append(names, cp.Spec.PullSecretRef.Name)
+	}
+	if cp.Spec.InstallConfigSecretTemplateRef != nil {
+		names = append(names, cp.Spec.InstallConfigSecretTemplateRef.Name)
+	}
+	_, providerSecretNames := getCPDetails(cp)
+	names = append(names, providerSecretNames...)
+	names = append(names, envSecretNames(cp.Spec.InstallerEnv)...)
+	return uniqueNames(names)
+}
+
+// getClusterDeploymentSecretNames returns every secret of its namespace the cluster deployment references
+func getClusterDeploymentSecretNames(cd hivev1.ClusterDeployment) []string {
+	names := []string{}
+	if cd.Spec.PullSecretRef != nil {
+		names = append(names, cd.Spec.PullSecretRef.Name)
+	}
+	if cd.Spec.BoundServiceAccountSigningKeySecretRef != nil {
+		names = append(names, cd.Spec.BoundServiceAccountSigningKeySecretRef.Name)
+	}
+	_, providerSecretNames := getPlatformDetails(cd.Spec.Platform)
+	names = append(names, providerSecretNames...)
+
+	for _, bundle := range cd.Spec.CertificateBundles {
+		names = append(names, secretNames(bundle.CertificateSecretRef)...)
+	}
+
+	if metadata := cd.Spec.ClusterMetadata; metadata != nil {
+		names = append(names, secretNames(metadata.AdminKubeconfigSecretRef)...)
+		for _, ref := range []*corev1.LocalObjectReference{metadata.AdminPasswordSecretRef, metadata.MetadataJSONSecretRef} {
+			if ref != nil {
+				names = append(names, secretNames(*ref)...)
+			}
+		}
+	}
+
+	if provisioning := cd.Spec.Provisioning; provisioning != nil {
+		for _, ref := range []*corev1.LocalObjectReference{
+			provisioning.InstallConfigSecretRef, provisioning.ManifestsSecretRef, provisioning.SSHPrivateKeySecretRef} {
+
+			if ref != nil {
+				names = append(names, secretNames(*ref)...)
+			}
+		}
+		names = append(names, envSecretNames(provisioning.InstallerEnv)...)
+	}
+	return uniqueNames(names)
+}
+
+// envSecretNames returns the secrets the installer environment variables are read from
+func envSecretNames(env []corev1.EnvVar) []string {
+	names := []string{}
+	for _, envVar := range env {
+		if envVar.ValueFrom != nil && envVar.ValueFrom.SecretKeyRef != nil {
+			names = append(names, secretNames(envVar.ValueFrom.SecretKeyRef.LocalObjectReference)...)
+		}
+	}
+	return names
+}
+
+// uniqueNames drops the repeated names, keeping their order
+func uniqueNames(names []string) []string {
+	seen := map[string]bool{}
+	unique := []string{}
+	for _, name := range names {
+		if !seen[name] {
+			seen[name] = true
+			unique = append(unique, name)
+		}
+	}
+	return unique
+}
+
+// getSecretReferences indexes the secrets referenced in the namespace of the cluster pool, by the other cluster
+// pools, the cluster deployments, the sync sets and the DNS zones. ClusterDeploymentCustomizations only hold
+// install-config patches, they do not reference secrets.
+func getSecretReferences(r *ClusterPoolsReconciler, cp *hivev1.ClusterPool) (secretReferences, error) {
+	ctx := context.Background()
+	listOptions := &client.ListOptions{Namespace: cp.Namespace}
+	refs := secretReferences{}
+
+	var cps hivev1.ClusterPoolList
+	if err := r.List(ctx, &cps, listOptions); err != nil {
+		return nil, err
+	}
+	for _, foundCp := range cps.Items {
+		// Skip the cluster pool being deleted
+		if foundCp.Name == cp.Name {
+			continue
+		}
+		refs.add("ClusterPool/"+foundCp.Name, getPoolSecretNames(foundCp)...)
+	}
+
+	var cds hivev1.ClusterDeploymentList
+	if err := r.List(ctx, &cds, listOptions); err != nil {
+		return nil, err
+	}
+	for _, cd := range cds.Items {
+		refs.add("ClusterDeployment/"+cd.Name, getClusterDeploymentSecretNames(cd)...)
+	}
+
+	var syncSets hivev1.SyncSetList
+	if err := r.List(ctx, &syncSets, listOptions); err != nil {
+		return nil, err
+	}
+	for _, syncSet := range syncSets.Items {
+		names := []string{}
+		for _, mapping := range syncSet.Spec.Secrets {
+			if mapping.SourceRef.Namespace == "" || mapping.SourceRef.Namespace == cp.Namespace {
+				names = append(names, mapping.SourceRef.Name)
+			}
+		}
+		refs.add("SyncSet/"+syncSet.Name, uniqueNames(names)...)
+	}
+
+	var dnsZones hivev1.DNSZoneList
+	if err := r.List(ctx, &dnsZones, listOptions); err != nil {
+		return nil, err
+	}
+	for _, dnsZone := range dnsZones.Items {
+		names := []string{}
+		switch {
+		case dnsZone.Spec.AWS != nil:
+			names = secretNames(dnsZone.Spec.AWS.CredentialsSecretRef)
+		case dnsZone.Spec.GCP != nil:
+			names = secretNames(dnsZone.Spec.GCP.CredentialsSecretRef)
+		case dnsZone.Spec.Azure != nil:
+			names = secretNames(dnsZone.Spec.Azure.CredentialsSecretRef)
+		}
+		refs.add("DNSZone/"+dnsZone.Name, names...)
+	}
+
+	return refs, nil
+}
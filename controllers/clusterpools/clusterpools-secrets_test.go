@@ -0,0 +1,255 @@
+package clusterpools
+
+import (
+	"context"
+	"testing"
+
+	hivev1 "github.com/openshift/hive/apis/hive/v1"
+	"github.com/openshift/hive/apis/hive/v1/aws"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func getSecretEnv(name string, secretName string) corev1.EnvVar {
+	return corev1.EnvVar{
+		Name: name,
+		ValueFrom: &corev1.EnvVarSource{
+			SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: secretName},
+				Key:                  name,
+			},
+		},
+	}
+}
+
+func getClusterDeployment(namespace string, name string) *hivev1.ClusterDeployment {
+	return &hivev1.ClusterDeployment{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+	}
+}
+
+func TestGetPoolSecretNames(t *testing.T) {
+
+	cp := GetClusterPool(CP_NAMESPACE, CP_NAME, "aws")
+	cp.Spec.InstallerEnv = []corev1.EnvVar{
+		{Name: "PLAIN", Value: "value"},
+		getSecretEnv("PROXY", "secret05"),
+		getSecretEnv("PROXY_CA", "secret05"),
+	}
+	assert.Equal(t, []string{"secret01", "secret02", "secret03", "secret05"}, getPoolSecretNames(*cp),
+		"pull secret, install-config, credentials and installer environment, without duplicates")
+
+	assert.Empty(t, getPoolSecretNames(*GetClusterPoolNoRefs(CP_NAMESPACE, CP_NAME, "")), "no secret references")
+}
+
+func TestGetClusterDeploymentSecretNames(t *testing.T) {
+
+	cd := getClusterDeployment(CP_NAMESPACE, CLUSTER01)
+	assert.Empty(t, getClusterDeploymentSecretNames(*cd), "no secret references")
+
+	cd.Spec = hivev1.ClusterDeploymentSpec{
+		PullSecretRef: &corev1.LocalObjectReference{Name: "pull"},
+		Platform: hivev1.Platform{
+			AWS: &aws.Platform{CredentialsSecretRef: corev1.LocalObjectReference{Name: "credentials"}},
+		},
+		CertificateBundles: []hivev1.CertificateBundleSpec{
+			{Name: "ingress", CertificateSecretRef: corev1.LocalObjectReference{Name: "bundle"}},
+		},
+		ClusterMetadata: &hivev1.ClusterMetadata{
+			AdminKubeconfigSecretRef: corev1.LocalObjectReference{Name: "kubeconfig"},
+			AdminPasswordSecretRef:   &corev1.LocalObjectReference{Name: "password"},
+		},
+		Provisioning: &hivev1.Provisioning{
+			InstallConfigSecretRef: &corev1.LocalObjectReference{Name: "install-config"},
+			SSHPrivateKeySecretRef: &corev1.LocalObjectReference{Name: "ssh"},
+			InstallerEnv:           []corev1.EnvVar{getSecretEnv("PROXY", "pull")},
+		},
+	}
+	assert.Equal(t,
+		[]string{"pull", "credentials", "bundle", "kubeconfig", "password", "install-config", "ssh"},
+		getClusterDeploymentSecretNames(*cd), "every secret reference of the cluster deployment")
+}
+
+var secretReferenceTests = []struct {
+	name     string
+	referrer client.Object
+}{
+	{"other pool installer environment", func() client.Object {
+		cp := GetClusterPoolNoRefs(CP_NAMESPACE, CP_NAME+"02", "")
+		cp.Spec.InstallerEnv = []corev1.EnvVar{getSecretEnv("TOKEN", "secret01")}
+		return cp
+	}()},
+	{"cluster deployment", func() client.Object {
+		cd := getClusterDeployment(CP_NAMESPACE, CLUSTER01)
+		cd.Spec.PullSecretRef = &corev1.LocalObjectReference{Name: "secret01"}
+		return cd
+	}()},
+	{"sync set", &hivev1.SyncSet{
+		ObjectMeta: v1.ObjectMeta{Name: "syncset01", Namespace: CP_NAMESPACE},
+		Spec: hivev1.SyncSetSpec{SyncSetCommonSpec: hivev1.SyncSetCommonSpec{
+			Secrets: []hivev1.SecretMapping{{SourceRef: hivev1.SecretReference{Name: "secret01"}}},
+		}},
+	}},
+	{"dns zone", &hivev1.DNSZone{
+		ObjectMeta: v1.ObjectMeta{Name: "zone01", Namespace: CP_NAMESPACE},
+		Spec: hivev1.DNSZoneSpec{
+			AWS: &hivev1.AWSDNSZoneSpec{CredentialsSecretRef: corev1.LocalObjectReference{Name: "secret01"}},
+		},
+	}},
+}
+
+func TestReconcileClusterPoolDeleteReferencedSecrets(t *testing.T) {
+
+	ctx := context.Background()
+
+	for _, tc := range secretReferenceTests {
+		cpr := GetClusterPoolsReconciler()
+
+		cp := GetClusterPool(CP_NAMESPACE, CP_NAME, "aws")
+		cpr.Client.Create(ctx, tc.referrer, &client.CreateOptions{})
+
+		for _, name := range []string{"secret01", "secret02", "secret03"} {
+			cpr.KubeClient.CoreV1().Secrets(CP_NAMESPACE).Create(ctx, getSecret(CP_NAMESPACE, name), v1.CreateOptions{})
+		}
+
+		err := deleteResources(cpr, cp)
+		assert.Nil(t, err, tc.name+", clusterPool resources are deleted")
+
+		_, err = cpr.KubeClient.CoreV1().Secrets(CP_NAMESPACE).Get(ctx, "secret01", v1.GetOptions{})
+		assert.Nil(t, err, tc.name+", the referenced pull secret is kept")
+
+		for _, name := range []string{"secret02", "secret03"} {
+			_, err = cpr.KubeClient.CoreV1().Secrets(CP_NAMESPACE).Get(ctx, name, v1.GetOptions{})
+			assert.NotNil(t, err, tc.name+", unreferenced secret "+name+" is deleted")
+		}
+	}
+}
+
+func TestReconcileClusterPoolDeleteInstallerEnvSecret(t *testing.T) {
+
+	ctx := context.Background()
+
+	cpr := GetClusterPoolsReconciler()
+
+	cp := GetClusterPoolNoRefs(CP_NAMESPACE, CP_NAME, "")
+	cp.Spec.InstallerEnv = []corev1.EnvVar{getSecretEnv("TOKEN", "secret05")}
+
+	// The sync set copies a secret of the same name from another namespace
+	cpr.Client.Create(ctx, &hivev1.SyncSet{
+		ObjectMeta: v1.ObjectMeta{Name: "syncset01", Namespace: CP_NAMESPACE},
+		Spec: hivev1.SyncSetSpec{SyncSetCommonSpec: hivev1.SyncSetCommonSpec{
+			Secrets: []hivev1.SecretMapping{{SourceRef: hivev1.SecretReference{Name: "secret05", Namespace: "other"}}},
+		}},
+	}, &client.CreateOptions{})
+
+	cpr.KubeClient.CoreV1().Secrets(CP_NAMESPACE).Create(ctx, getSecret(CP_NAMESPACE, "secret05"), v1.CreateOptions{})
+
+	err := deleteResources(cpr, cp)
+	assert.Nil(t, err, "nil, when clusterPool resources are deleted")
+
+	_, err = cpr.KubeClient.CoreV1().Secrets(CP_NAMESPACE).Get(ctx, "secret05", v1.GetOptions{})
+	assert.NotNil(t, err, "not nil, the installer environment secret is deleted")
+}
+
+func TestReconcileClusterPoolDeleteProtectedSecret(t *testing.T) {
+
+	ctx := context.Background()
+
+	cpr := GetClusterPoolsReconciler()
+	cpr.KubeClient.CoreV1().Namespaces().Create(ctx,
+		getNamespace(CP_NAMESPACE, map[string]string{LABEL_NAMESPACE: CLUSTERPOOLS}), v1.CreateOptions{})
+
+	cp := GetClusterPool(CP_NAMESPACE, CP_NAME, "aws")
+
+	protected := getSecret(CP_NAMESPACE, "secret03")
+	protected.Annotations = map[string]string{PROTECT_SECRET: "True"}
+	cpr.KubeClient.CoreV1().Secrets(CP_NAMESPACE).Create(ctx, protected, v1.CreateOptions{})
+	cpr.KubeClient.CoreV1().Secrets(CP_NAMESPACE).Create(ctx, getSecret(CP_NAMESPACE, "secret01"), v1.CreateOptions{})
+
+	err := deleteResources(cpr, cp)
+	assert.Nil(t, err, "nil, when clusterPool resources are deleted")
+
+	_, err = cpr.KubeClient.CoreV1().Secrets(CP_NAMESPACE).Get(ctx, "secret01", v1.GetOptions{})
+	assert.NotNil(t, err, "not nil, the pull secret is deleted")
+
+	_, err = cpr.KubeClient.CoreV1().Secrets(CP_NAMESPACE).Get(ctx, "secret03", v1.GetOptions{})
+	assert.Nil(t, err, "nil, the protected secret is kept")
+
+	_, err = cpr.KubeClient.CoreV1().Namespaces().Get(ctx, CP_NAMESPACE, v1.GetOptions{})
+	assert.Nil(t, err, "nil, the namespace holding a protected secret is kept")
+}
+
+func TestReconcileClusterPoolRetainSecrets(t *testing.T) {
+
+	ctx := context.Background()
+
+	cpr := GetClusterPoolsReconciler()
+	cpr.KubeClient.CoreV1().Namespaces().Create(ctx,
+		getNamespace(CP_NAMESPACE, map[string]string{LABEL_NAMESPACE: CLUSTERPOOLS}), v1.CreateOptions{})
+
+	cp := GetClusterPool(CP_NAMESPACE, CP_NAME, "aws")
+	cp.Annotations = map[string]string{RETAIN_SECRETS: "true"}
+
+	for _, name := range []string{"secret01", "secret02", "secret03"} {
+		cpr.KubeClient.CoreV1().Secrets(CP_NAMESPACE).Create(ctx, getSecret(CP_NAMESPACE, name), v1.CreateOptions{})
+	}
+
+	err := deleteResources(cpr, cp)
+	assert.Nil(t, err, "nil, when clusterPool resources are deleted")
+
+	for _, name := range []string{"secret01", "secret02", "secret03"} {
+		_, err = cpr.KubeClient.CoreV1().Secrets(CP_NAMESPACE).Get(ctx, name, v1.GetOptions{})
+		assert.Nil(t, err, "nil, retained secret "+name+" is kept")
+	}
+
+	_, err = cpr.KubeClient.CoreV1().Namespaces().Get(ctx, CP_NAMESPACE, v1.GetOptions{})
+	assert.Nil(t, err, "nil, the namespace of a pool retaining its secrets is kept")
+}
+
+func TestReconcileClusterPoolDeleteLabeledSecretsOnly(t *testing.T) {
+
+	ctx := context.Background()
+
+	cpr := GetClusterPoolsReconciler()
+	cpr.DeleteLabeledSecretsOnly = true
+	cpr.KubeClient.CoreV1().Namespaces().Create(ctx,
+		getNamespace(CP_NAMESPACE, map[string]string{LABEL_NAMESPACE: CLUSTERPOOLS}), v1.CreateOptions{})
+
+	cp := GetClusterPool(CP_NAMESPACE, CP_NAME, "aws")
+
+	labeled := getSecret(CP_NAMESPACE, "secret01")
+	labeled.Labels = map[string]string{LABEL_NAMESPACE: CLUSTERPOOLS}
+	cpr.KubeClient.CoreV1().Secrets(CP_NAMESPACE).Create(ctx, labeled, v1.CreateOptions{})
+	cpr.KubeClient.CoreV1().Secrets(CP_NAMESPACE).Create(ctx, getSecret(CP_NAMESPACE, "secret03"), v1.CreateOptions{})
+
+	err := deleteResources(cpr, cp)
+	assert.Nil(t, err, "nil, when clusterPool resources are deleted")
+
+	_, err = cpr.KubeClient.CoreV1().Secrets(CP_NAMESPACE).Get(ctx, "secret01", v1.GetOptions{})
+	assert.NotNil(t, err, "not nil, the labeled secret is deleted")
+
+	_, err = cpr.KubeClient.CoreV1().Secrets(CP_NAMESPACE).Get(ctx, "secret03", v1.GetOptions{})
+	assert.Nil(t, err, "nil, the hand-created secret is kept")
+
+	_, err = cpr.KubeClient.CoreV1().Namespaces().Get(ctx, CP_NAMESPACE, v1.GetOptions{})
+	assert.Nil(t, err, "nil, the namespace holding a hand-created secret is kept")
+
+	// Once the hand-created secret is gone, service account secrets do not keep the namespace
+	err = cpr.KubeClient.CoreV1().Secrets(CP_NAMESPACE).Delete(ctx, "secret03", v1.DeleteOptions{})
+	assert.Nil(t, err, "nil, when the hand-created secret is deleted")
+
+	token := getSecret(CP_NAMESPACE, "default-token")
+	token.Annotations = map[string]string{corev1.ServiceAccountNameKey: "default"}
+	cpr.KubeClient.CoreV1().Secrets(CP_NAMESPACE).Create(ctx, token, v1.CreateOptions{})
+
+	err = deleteResources(cpr, cp)
+	assert.Nil(t, err, "nil, when clusterPool resources are deleted")
+
+	_, err = cpr.KubeClient.CoreV1().Namespaces().Get(ctx, CP_NAMESPACE, v1.GetOptions{})
+	assert.NotNil(t, err, "not nil, the namespace is deleted")
+}